@@ -489,20 +489,60 @@ func (d *Device) set(attr string, value interface{}, disconnect bool) error {
 		req.Args["disconnect"] = "true"
 	}
 	req.Args["name"] = attr
+	v, err := formatValue(value)
+	if err != nil {
+		return err
+	}
+	req.Args["value"] = v
+	_, err = d.Exec(req)
+	return err
+}
+
+func formatValue(value interface{}) (string, error) {
 	switch value.(type) {
 	case int, int8, int16, int32, int64:
-		req.Args["value"] = fmt.Sprintf("%d", value)
+		return fmt.Sprintf("%d", value), nil
 	case float32, float64:
-		req.Args["value"] = fmt.Sprintf("%f", value)
+		return fmt.Sprintf("%f", value), nil
 	case bool:
-		req.Args["value"] = fmt.Sprintf("%t", value)
+		return fmt.Sprintf("%t", value), nil
 	case string:
-		req.Args["value"] = value.(string)
-	default:
-		return errors.New("unknown data type")
+		return value.(string), nil
 	}
-	_, err := d.Exec(req)
-	return err
+	return "", errors.New("unknown data type")
+}
+
+// Call invokes a function listed by the device, every argument it declares must be given.
+// The request is a call packet naming the function in func, the same key the device lists its func.arg
+// packets under, with one key per argument. It returns the packets the device answered with
+func (d *Device) Call(name string, args map[string]interface{}) ([]Packet, error) {
+	d.lock.RLock()
+	fn, ok := d.functions[name]
+	d.lock.RUnlock()
+	if !ok {
+		return nil, errors.New("unknown function")
+	}
+
+	req := Packet{
+		Command: "call",
+		Args:    make(map[string]string),
+	}
+	req.Args["func"] = name
+	for _, arg := range fn.Args {
+		value, ok := args[arg.Name]
+		if !ok {
+			return nil, errors.New("missing argument " + arg.Name)
+		}
+		v, err := formatValue(value)
+		if err != nil {
+			return nil, err
+		}
+		req.Args[arg.Name] = v
+	}
+	if len(req.Args) != len(args)+1 {
+		return nil, errors.New("unknown argument")
+	}
+	return d.Exec(req)
 }
 
 func (d Device) log(verbose bool) *log.Logger {
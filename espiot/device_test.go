@@ -0,0 +1,53 @@
+package espiot
+
+import (
+	"testing"
+)
+
+func TestCall(t *testing.T) {
+	d := &Device{
+		connected: true,
+		execute:   make(chan request),
+		functions: map[string]Function{
+			"blink": {Name: "blink", Args: []FunctionArg{{Name: "times", Type: "integer"}, {Name: "fast", Type: "bool"}}},
+		},
+	}
+	sent := make(chan Packet, 1)
+	go func() {
+		for req := range d.execute {
+			sent <- req.Packet
+			req.Response <- []Packet{{Command: "blinked"}}
+		}
+	}()
+	defer close(d.execute)
+
+	res, err := d.Call("blink", map[string]interface{}{"times": 3, "fast": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || res[0].Command != "blinked" {
+		t.Errorf("expected the answer of the device got %+v", res)
+	}
+	p := <-sent
+	if p.Command != "call" || len(p.Args) != 3 || p.Args["func"] != "blink" || p.Args["times"] != "3" || p.Args["fast"] != "true" {
+		t.Errorf("unexpected packet %s", Encode(p))
+	}
+
+	for _, args := range []map[string]interface{}{
+		{"times": 3},
+		{"times": 3, "fast": true, "slow": true},
+		{"times": []int{3}, "fast": true},
+	} {
+		if _, err := d.Call("blink", args); err == nil {
+			t.Errorf("expected %v to be rejected", args)
+		}
+	}
+	if _, err := d.Call("unknown", nil); err == nil {
+		t.Error("expected an unknown function to be rejected")
+	}
+	select {
+	case p := <-sent:
+		t.Errorf("expected nothing sent for a rejected call got %s", Encode(p))
+	default:
+	}
+}
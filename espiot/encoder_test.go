@@ -5,8 +5,8 @@ import (
 )
 
 func TestEncodeAndDecode(t *testing.T) {
-	c := Command{
-		Name: "TEST",
+	c := Packet{
+		Command: "TEST",
 		Args: map[string]string{
 			"a": "a value",
 			"b": "b value",
@@ -20,7 +20,7 @@ func TestEncodeAndDecode(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if c.Name != c2.Name {
+	if c.Command != c2.Command {
 		t.Fatal("mismatch")
 	}
 	if len(c.Args) != len(c2.Args) {
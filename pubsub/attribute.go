@@ -1,5 +1,7 @@
 package pubsub
 
+import "context"
+
 type Definition interface {
 	ValidateAndTransform(interface{}) (interface{}, error)
 	Inspect(interface{}) string
@@ -7,7 +9,42 @@ type Definition interface {
 	Accept(interface{}) error
 }
 
+// ContextAcceptor is implemented by definitions whose Accept can be cancelled, the broker prefers it over Accept
+type ContextAcceptor interface {
+	AcceptContext(ctx context.Context, v interface{}) error
+}
+
+// accept calls the Accept of d bounded by ctx
+func accept(ctx context.Context, d Definition, v interface{}) error {
+	if a, ok := d.(ContextAcceptor); ok {
+		return a.AcceptContext(ctx, v)
+	}
+	return acceptWithin(ctx, d.Accept, v)
+}
+
+// acceptWithin bounds an accept function that does not take a context, when ctx is done first
+// the function is left running in the background and its result is discarded
+func acceptWithin(ctx context.Context, fn func(interface{}) error, v interface{}) error {
+	if ctx.Done() == nil {
+		return fn(v)
+	}
+	res := make(chan error, 1)
+	go func() {
+		res <- fn(v)
+	}()
+	select {
+	case err := <-res:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 type Attribute struct {
 	Name string
 	Definition
+	Retention RetentionPolicy
+	Access    AccessMode
+	Change    ChangeFilter
+	Derive    Derivation
 }
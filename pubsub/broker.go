@@ -1,169 +1,879 @@
 package pubsub
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"reflect"
+	"sort"
+	"sync"
 	"time"
 )
 
+// DefaultMaxHops is the longest chain of subscription triggered publishes allowed when Broker.MaxHops is not set
+const DefaultMaxHops = 32
+
 type Broker struct {
 	Log           *log.Logger
+	Store         Store
+	MaxHops       int
+	lock          sync.RWMutex
 	attributes    map[string]*attributeCtx
 	subscriptions map[string]*subscriptionCtx
+	functions     map[string]*functionCtx
+	retention     []retentionFilter
+	// attributeIndex holds attribute ids and subscriptionIndex holds subscription filters
+	attributeIndex    segmentIndex
+	subscriptionIndex segmentIndex
+	// derivedIndex holds the input filters of derived attributes
+	derivedIndex segmentIndex
+	// functionIndex holds function ids
+	functionIndex segmentIndex
 }
 
 type attributeCtx struct {
-	Attribute Attribute
-	Records   []ValueRecord
+	Attribute    Attribute
+	id           string
+	node         string
+	store        Store
+	lock         sync.RWMutex
+	removed      bool
+	retention    RetentionPolicy
+	compactedAt  time.Time
+	pruned       bool
+	nextRecordId int
+	Records      []*ValueRecord
+	change       changeState
+
+	// fanoutSeq is the id of the next record allowed to enqueue to asynchronous subscriptions
+	fanoutLock sync.Mutex
+	fanoutCond *sync.Cond
+	fanoutSeq  int
+
+	// deriving is set while a derived value is computed and published, a recompute asked for meanwhile
+	// is left in redrive for the running one to carry out once it is done
+	deriveLock sync.Mutex
+	deriving   bool
+	redrive    *pendingDerive
 }
 
 type subscriptionCtx struct {
 	Subscription
+	node    string
+	lock    sync.RWMutex
+	removed bool
+	queue   *deliveryQueue
+	// snapshot is the id of the record of each attribute delivered by the snapshot, it is written before
+	// the subscription is indexed and only read afterwards
+	snapshot map[*attributeCtx]int
+	// snapshotting holds live deliveries in held until the snapshot is delivered
+	snapshotting bool
+	held         []heldDelivery
+	pacing       map[*attributeCtx]*paceState
+	conditions   map[*attributeCtx]*conditionState
+}
+
+func (ctx *subscriptionCtx) remove() {
+	ctx.lock.Lock()
+	ctx.removed = true
+	ctx.stopPacing()
+	ctx.lock.Unlock()
+	if ctx.queue != nil {
+		markDropped(ctx.queue.close())
+	}
+}
+
+func (ctx *subscriptionCtx) active() bool {
+	ctx.lock.RLock()
+	defer ctx.lock.RUnlock()
+	return !ctx.removed
+}
+
+type fanoutTarget struct {
+	id  string
+	sub *subscriptionCtx
 }
 
-func (ctx Broker) log() *log.Logger {
+func (ctx *Broker) log() *log.Logger {
 	if ctx.Log == nil {
 		return log.New(ioutil.Discard, "", 0)
 	}
 	return ctx.Log
 }
 
-func (ctx *Broker) publish(publisher string, attr string, value interface{}) (err error) {
+func (ctx *Broker) attribute(attr string) (*attributeCtx, bool) {
+	ctx.lock.RLock()
+	defer ctx.lock.RUnlock()
+	if ctx.attributes == nil {
+		return nil, false
+	}
+	recCtx, ok := ctx.attributes[attr]
+	return recCtx, ok
+}
+
+// fanoutTargets returns a snapshot of the subscriptions matching attr, ordered by subscription id,
+// so the subscription functions can be called without holding the broker lock
+func (ctx *Broker) fanoutTargets(attr string) []fanoutTarget {
+	ctx.lock.RLock()
+	defer ctx.lock.RUnlock()
+	var targets []fanoutTarget
+	for _, id := range ctx.subscriptionIndex.matchFilters(attr) {
+		targets = append(targets, fanoutTarget{id: id, sub: ctx.subscriptions[id]})
+	}
+	return targets
+}
+
+func (ctx *Broker) maxHops() int {
+	if ctx.MaxHops <= 0 {
+		return DefaultMaxHops
+	}
+	return ctx.MaxHops
+}
+
+// publish records value, cause is the record being delivered when the publish comes from a subscription.
+// c bounds the Accept of the definition and is handed on to the synchronous subscriptions
+func (ctx *Broker) publish(c context.Context, publisher string, attr string, value interface{}, cause *ValueRecord, inputs []RecordRef) (err error) {
 	ctx.log().Printf("publish attribute:'%s' publisher:'%s'", attr, publisher)
 	defer func() {
-		if err != nil {
+		if err != nil && !errors.As(err, &ErrSuppressed{}) {
 			ctx.log().Printf("error publish attribute:'%s' publisher:'%s' err: %s", attr, publisher, err)
 		}
 	}()
-	if ctx.attributes != nil {
-		if recCtx, ok := ctx.attributes[attr]; ok {
-			value, err = recCtx.Attribute.Definition.ValidateAndTransform(value)
-			if err != nil {
-				err = fmt.Errorf("validateAndTransform error %w, thrown by '%s'", err, attr)
-				return
-			}
-			if !isOwner(attr, publisher) {
-				if err = recCtx.Attribute.Definition.Accept(value); err != nil {
-					err = fmt.Errorf("accept error %w, thrown by '%s'", err, attr)
-					return
-				}
-			}
+	recCtx, ok := ctx.attribute(attr)
+	if !ok {
+		err = ErrUnknownAttribute{Attribute: attr}
+		return
+	}
 
-			rec := ValueRecord{
-				RecordId: len(recCtx.Records),
-				Value: Value{
-					AttributeID: attr,
-					Value:       value,
-					inspected:   recCtx.Attribute.Definition.Inspect(value),
-					UpdatedBy:   publisher,
-					UpdatedAt:   time.Now(),
-				},
-			}
+	v := Value{
+		AttributeID: attr,
+		UpdatedBy:   publisher,
+		Inputs:      inputs,
+	}
+	if cause != nil {
+		v.CausedBy = cause.Ref()
+		v.Correlation = cause.Correlation
+		v.Hops = cause.Hops + 1
+		if v.Hops > ctx.maxHops() {
+			err = ErrMaxHops{Attribute: attr, Hops: v.Hops, CausedBy: v.CausedBy, Correlation: v.Correlation}
+			return
+		}
+	}
+
+	if recCtx.Attribute.Access == AccessReadOnly && !isOwner(attr, publisher) {
+		err = ErrReadOnly{Attribute: attr, Publisher: publisher}
+		return
+	}
 
-			ctx.log().Printf("set attribute:'%s' value:'%s' publisher:'%s'", attr, rec.Value.Inspect(), rec.UpdatedBy)
-			if ctx.subscriptions != nil {
-				for k, sub := range ctx.subscriptions {
-					keyMatch := KeyMatch(attr, sub.Subscription.Filter)
-					if keyMatch {
-						ctx.log().Printf("fanout subscription:'%s' publisher: '%s' filter: '%s' attribute:'%s' value:'%s'", k, publisher, sub.Subscription.Filter, attr, rec.Value.Inspect())
-						execCtx := &executionContext{
-							broker:    ctx,
-							publisher: k,
-						}
-						sub.Fn(execCtx, rec.Value)
-						res := SubscriptionResponse{
-							SubscriptionID: k,
-						}
-						for _, err := range execCtx.errors {
-							ctx.log().Printf("error fanout subscription:'%s' attribute:'%s' value:'%s' err: %s", k, attr, rec.Value.Inspect(), err)
-							res.Err = append(res.Err, err)
-						}
-						rec.SubscriptionResponses = append(rec.SubscriptionResponses, res)
-					} else {
-						ctx.log().Printf("skip fanout subscription:'%s' publisher: '%s' filter; '%s' attribute:'%s' value:'%s'", k, publisher, sub.Subscription.Filter, attr, rec.Value.Inspect())
-					}
-				}
+	value, err = recCtx.Attribute.Definition.ValidateAndTransform(value)
+	if err != nil {
+		err = fmt.Errorf("validateAndTransform error %w, thrown by '%s'", err, attr)
+		return
+	}
+	if err = c.Err(); err != nil {
+		err = ErrPublishTimeout{Attribute: attr, Err: err}
+		return
+	}
+	if !isOwner(attr, publisher) {
+		if err = accept(c, recCtx.Attribute.Definition, value); err != nil {
+			if c.Err() != nil {
+				err = ErrPublishTimeout{Attribute: attr, Err: c.Err()}
+				return
 			}
-			recCtx.Records = append(recCtx.Records, rec)
+			err = fmt.Errorf("accept error %w, thrown by '%s'", err, attr)
 			return
 		}
 	}
-	err = ErrUnknownAttribute{Attribute: attr}
+
+	v.Value = value
+	v.Unit = unitOf(recCtx.Attribute.Definition)
+	v.inspected = recCtx.Attribute.Definition.Inspect(value)
+	rec, reason, ok, storeErr := recCtx.append(v)
+	if !ok {
+		// the attribute was removed while this publish was in flight
+		err = ErrUnknownAttribute{Attribute: attr}
+		return
+	}
+	if rec == nil {
+		ctx.log().Printf("suppressed attribute:'%s' value:'%s' publisher:'%s' reason:'%s'", attr, v.Inspect(), publisher, reason)
+		err = ErrSuppressed{Attribute: attr, Reason: reason}
+		return
+	}
+	if storeErr != nil {
+		ctx.log().Printf("error store attribute:'%s' publisher:'%s' err: %s", attr, publisher, storeErr)
+	}
+	ctx.log().Printf("set attribute:'%s' value:'%s' publisher:'%s'", attr, rec.Value.Inspect(), rec.UpdatedBy)
+
+	ctx.fanout(c, publisher, recCtx, rec)
 	return
 }
 
-func (ctx attributeCtx) Value(at time.Time) (ValueRecord, error) {
+// fanout delivers rec to every matching subscription. Asynchronous subscriptions are queued first,
+// in record order, then the synchronous ones are called without any broker lock held so they are free to publish
+func (ctx *Broker) fanout(c context.Context, publisher string, recCtx *attributeCtx, rec *ValueRecord) {
+	syncTargets := ctx.fanoutInTurn(c, publisher, recCtx, rec)
+	ctx.fanoutSync(c, publisher, recCtx, rec, syncTargets)
+}
+
+// fanoutInTurn evaluates the conditions of every matching subscription and queues rec to the asynchronous
+// ones in record order, it returns the synchronous subscriptions to call
+func (ctx *Broker) fanoutInTurn(c context.Context, publisher string, recCtx *attributeCtx, rec *ValueRecord) []fanoutTarget {
+	targets := ctx.fanoutTargets(rec.AttributeID)
+
+	// conditions are evaluated in record order since their hysteresis depends on it
+	var syncTargets, asyncTargets []fanoutTarget
+	recCtx.waitTurn(rec.RecordId)
+	for _, target := range targets {
+		if target.sub.delivered(recCtx, rec) || !target.sub.holds(recCtx, rec) {
+			continue
+		}
+		if target.sub.queue == nil {
+			syncTargets = append(syncTargets, target)
+			continue
+		}
+		if !target.sub.active() || target.sub.hold(c, publisher, recCtx, rec) {
+			continue
+		}
+		ctx.dispatch(c, target.id, target.sub, publisher, recCtx, rec)
+		asyncTargets = append(asyncTargets, target)
+	}
+	recCtx.doneTurn(rec.RecordId)
+
+	// blocking queues took rec already, the publisher waits for room now that the next record can go
+	if c.Value(workerKey{}) == nil {
+		for _, target := range asyncTargets {
+			target.sub.queue.waitRoom()
+		}
+	}
+	return syncTargets
+}
+
+// fanoutSync calls the synchronous subscriptions of rec and recomputes the attributes derived from it
+func (ctx *Broker) fanoutSync(c context.Context, publisher string, recCtx *attributeCtx, rec *ValueRecord, syncTargets []fanoutTarget) {
+	attr := rec.AttributeID
+	for _, target := range syncTargets {
+		if !target.sub.active() {
+			ctx.log().Printf("skip fanout removed subscription:'%s' attribute:'%s'", target.id, attr)
+			continue
+		}
+		if target.sub.hold(c, publisher, recCtx, rec) {
+			continue
+		}
+		ctx.dispatch(c, target.id, target.sub, publisher, recCtx, rec)
+	}
+
+	ctx.derive(c, rec)
+}
+
+// enqueue hands rec to the queue of an asynchronous subscription
+func (ctx *Broker) enqueue(c context.Context, id string, sub *subscriptionCtx, publisher string, recCtx *attributeCtx, rec *ValueRecord, snapshot bool) {
+	attr := rec.AttributeID
+	ctx.log().Printf("queue subscription:'%s' publisher: '%s' filter: '%s' attribute:'%s' value:'%s'", id, publisher, sub.Subscription.Filter, attr, rec.Value.Inspect())
+	d := delivery{
+		// the publisher is long gone by the time the value is delivered so only the values of c are kept
+		ctx:       detachedContext{c},
+		recCtx:    recCtx,
+		rec:       rec,
+		publisher: publisher,
+		attempt:   1,
+		response: recCtx.respond(rec, SubscriptionResponse{
+			SubscriptionID: id,
+			Pending:        true,
+			Snapshot:       snapshot,
+		}),
+	}
+	ctx.push(id, sub, d)
+}
+
+// push queues d to an asynchronous subscription and records the state of its queue on the response
+func (ctx *Broker) push(id string, sub *subscriptionCtx, d delivery) {
+	depth, totalDropped, dropped := sub.queue.push(d)
+	d.recCtx.updateResponse(d.rec, d.response, func(res *SubscriptionResponse) {
+		res.QueueDepth = depth
+		res.TotalDropped = totalDropped
+	})
+	if len(dropped) > 0 {
+		ctx.log().Printf("drop subscription:'%s' attribute:'%s' dropped: %d total: %d", id, d.rec.AttributeID, len(dropped), totalDropped)
+		markDropped(dropped)
+	}
+}
+
+// callAndRespond calls a synchronous subscription and records its response
+func (ctx *Broker) callAndRespond(c context.Context, id string, sub *subscriptionCtx, publisher string, recCtx *attributeCtx, rec *ValueRecord, snapshot bool) {
+	errs := ctx.call(c, id, sub, publisher, recCtx, rec)
+	i := recCtx.respond(rec, SubscriptionResponse{
+		SubscriptionID: id,
+		Err:            errs,
+		Snapshot:       snapshot,
+		Attempts:       1,
+		Retrying:       sub.retrying(1, errs),
+	})
+	ctx.retry(c, id, sub, publisher, recCtx, rec, i, 1, errs)
+}
+
+// call runs the subscription function and returns the errors it reported
+func (ctx *Broker) call(c context.Context, id string, sub *subscriptionCtx, publisher string, recCtx *attributeCtx, rec *ValueRecord) []error {
+	v := rec.Value
+	ctx.log().Printf("fanout subscription:'%s' publisher: '%s' filter: '%s' attribute:'%s' value:'%s'", id, publisher, sub.Subscription.Filter, v.AttributeID, v.Inspect())
+	if sub.Timeout > 0 {
+		var cancel context.CancelFunc
+		c, cancel = context.WithTimeout(c, sub.Timeout)
+		defer cancel()
+	}
+	execCtx := &executionContext{
+		ctx:       c,
+		broker:    ctx,
+		publisher: id,
+		cause:     rec,
+		sub:       sub,
+		recCtx:    recCtx,
+	}
+	converted, err := sub.view(v)
+	if err != nil {
+		ctx.log().Printf("error fanout subscription:'%s' attribute:'%s' value:'%s' err: %s", id, v.AttributeID, v.Inspect(), err)
+		return []error{err}
+	}
+	v = converted
+	sub.Fn(execCtx, v)
+	if sub.Timeout > 0 && c.Err() == context.DeadlineExceeded {
+		execCtx.Error(ErrSubscriptionTimeout{Subscription: id, Attribute: v.AttributeID, Timeout: sub.Timeout})
+	}
+	errs := execCtx.Errors()
+	for _, err := range errs {
+		ctx.log().Printf("error fanout subscription:'%s' attribute:'%s' value:'%s' err: %s", id, v.AttributeID, v.Inspect(), err)
+	}
+	return errs
+}
+
+// append stamps v and stores it as the newest record in UpdatedAt order, unless the ChangeFilter of the
+// attribute suppresses it in which case the record is nil. ok is false when the attribute was removed.
+// A store error does not undo the append, the value is still live in memory
+func (ctx *attributeCtx) append(v Value) (*ValueRecord, SuppressReason, bool, error) {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+	if ctx.removed {
+		return nil, NotSuppressed, false, nil
+	}
+	v.UpdatedAt = time.Now()
+	if reason := ctx.suppressReason(v, v.UpdatedAt); reason != NotSuppressed {
+		ctx.suppress(v, reason, v.UpdatedAt)
+		return nil, reason, true, nil
+	}
+	rec := &ValueRecord{
+		RecordId: ctx.nextRecordId,
+		Value:    v,
+	}
+	if rec.Correlation.IsZero() {
+		rec.Correlation = rec.Ref()
+	}
+	ctx.recorded(rec)
+	ctx.nextRecordId++
+	ctx.Records = append(ctx.Records, rec)
+	var err error
+	if ctx.store != nil {
+		stored := *rec
+		stored.Value.Value = encodeValue(ctx.Attribute.Definition, v.Value)
+		err = ctx.store.Append(stored)
+	}
+	if dropErr := ctx.applyRetention(v.UpdatedAt); err == nil {
+		err = dropErr
+	}
+	return rec, NotSuppressed, true, err
+}
+
+// replay loads the persisted history without calling Accept, values are restored to the type
+// of the definition since the store may not preserve it. It returns false if there was nothing to load
+func (ctx *attributeCtx) replay() (bool, error) {
+	if ctx.store == nil {
+		return false, nil
+	}
+	recs, err := ctx.store.Load(ctx.id)
+	if err != nil || len(recs) == 0 {
+		return false, err
+	}
+
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+	for _, rec := range recs {
+		value, err := ctx.Attribute.Definition.ValidateAndTransform(rec.Value.Value)
+		if err != nil {
+			return false, fmt.Errorf("replay record %d error %w, thrown by '%s'", rec.RecordId, err, ctx.id)
+		}
+		rec := rec
+		rec.Value.Value = value
+		rec.Value.Unit = unitOf(ctx.Attribute.Definition)
+		rec.Value.inspected = ctx.Attribute.Definition.Inspect(value)
+		ctx.Records = append(ctx.Records, &rec)
+		if rec.RecordId >= ctx.nextRecordId {
+			ctx.nextRecordId = rec.RecordId + 1
+		}
+	}
+	ctx.fanoutSeq = ctx.nextRecordId
+	return true, nil
+}
+
+func (ctx *attributeCtx) remove() {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+	ctx.removed = true
+	if ctx.change.flushTimer != nil {
+		ctx.change.flushTimer.Stop()
+		ctx.change.flushTimer = nil
+	}
+}
+
+// respond adds a subscription response to rec and returns its index
+func (ctx *attributeCtx) respond(rec *ValueRecord, res SubscriptionResponse) int {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+	rec.SubscriptionResponses = append(rec.SubscriptionResponses, res)
+	return len(rec.SubscriptionResponses) - 1
+}
+
+// copyRecord copies rec for a caller outside the attribute lock, its responses keep being updated
+// by deliveries that are still running so they are copied too. Lock held
+func copyRecord(rec *ValueRecord) ValueRecord {
+	c := *rec
+	c.SubscriptionResponses = append([]SubscriptionResponse(nil), rec.SubscriptionResponses...)
+	return c
+}
+
+func (ctx *attributeCtx) updateResponse(rec *ValueRecord, i int, fn func(res *SubscriptionResponse)) {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+	fn(&rec.SubscriptionResponses[i])
+}
+
+// waitTurn blocks until every record before recordId has been queued to the asynchronous subscriptions
+func (ctx *attributeCtx) waitTurn(recordId int) {
+	ctx.fanoutLock.Lock()
+	defer ctx.fanoutLock.Unlock()
+	if ctx.fanoutCond == nil {
+		ctx.fanoutCond = sync.NewCond(&ctx.fanoutLock)
+	}
+	for ctx.fanoutSeq < recordId {
+		ctx.fanoutCond.Wait()
+	}
+}
+
+func (ctx *attributeCtx) doneTurn(recordId int) {
+	ctx.fanoutLock.Lock()
+	defer ctx.fanoutLock.Unlock()
+	ctx.fanoutSeq = recordId + 1
+	if ctx.fanoutCond != nil {
+		ctx.fanoutCond.Broadcast()
+	}
+}
+
+func (ctx *attributeCtx) Value(at time.Time) (ValueRecord, error) {
+	ctx.lock.RLock()
+	defer ctx.lock.RUnlock()
 	for i := len(ctx.Records) - 1; i >= 0; i-- {
 		if ctx.Records[i].UpdatedAt.Before(at) {
-			return ctx.Records[i], nil
+			return copyRecord(ctx.Records[i]), nil
+		}
+	}
+	if ctx.pruned {
+		return ValueRecord{}, ErrNotRetained{Attribute: ctx.id, Timestamp: at, RetainedFrom: ctx.Records[0].UpdatedAt}
+	}
+	return ValueRecord{}, ErrNoValue{Attribute: ctx.id, Timestamp: at}
+}
+
+// record looks up a retained record by id, records are ordered by id so this is a binary search
+func (ctx *attributeCtx) record(recordId int) (ValueRecord, bool) {
+	ctx.lock.RLock()
+	defer ctx.lock.RUnlock()
+	i := sort.Search(len(ctx.Records), func(i int) bool {
+		return ctx.Records[i].RecordId >= recordId
+	})
+	if i < len(ctx.Records) && ctx.Records[i].RecordId == recordId {
+		return copyRecord(ctx.Records[i]), true
+	}
+	return ValueRecord{}, false
+}
+
+// CausalChain walks from the record ref back to the root of its chain, following CausedBy.
+// The first record is ref itself and the last is the one that was published directly
+func (ctx *Broker) CausalChain(ref RecordRef) ([]ValueRecord, error) {
+	var chain []ValueRecord
+	for !ref.IsZero() {
+		recCtx, ok := ctx.attribute(ref.AttributeID)
+		if !ok {
+			return chain, ErrUnknownAttribute{Attribute: ref.AttributeID}
+		}
+		rec, ok := recCtx.record(ref.RecordId)
+		if !ok {
+			return chain, ErrUnknownRecord{Record: ref}
 		}
+		chain = append(chain, rec)
+		ref = rec.CausedBy
 	}
-	return ValueRecord{}, ErrNoValue{Attribute: ctx.Attribute.Name, Timestamp: at}
+	return chain, nil
 }
 
 func (ctx *Broker) Values(filter string, at time.Time) []ValueRecord {
 	var recs []ValueRecord
-	if ctx.attributes != nil {
-		for k, a := range ctx.attributes {
-			if KeyMatch(k, filter) {
-				if v, err := a.Value(at); err == nil {
-					recs = append(recs, v)
-				}
-			}
+	for _, a := range ctx.matching(filter) {
+		if v, err := a.Value(at); err == nil {
+			recs = append(recs, v)
 		}
 	}
 	return recs
 }
 
 func (ctx *Broker) Value(attr string, at time.Time) (ValueRecord, error) {
-	if ctx.attributes != nil {
-		if rec, ok := ctx.attributes[attr]; ok {
-			return rec.Value(at)
-		}
+	if rec, ok := ctx.attribute(attr); ok {
+		return rec.Value(at)
 	}
 	return ValueRecord{}, ErrUnknownAttribute{Attribute: attr}
 }
 
+// Definition returns the definition of attr so clients can inspect the values it accepts
+func (ctx *Broker) Definition(attr string) (Definition, error) {
+	if recCtx, ok := ctx.attribute(attr); ok {
+		return recCtx.Attribute.Definition, nil
+	}
+	return nil, ErrUnknownAttribute{Attribute: attr}
+}
+
 func (ctx *Broker) Publish(publisher Node, attr string, value interface{}) error {
-	return ctx.publish(publisher.NodeId(), attr, value)
+	return ctx.PublishContext(context.Background(), publisher, attr, value)
 }
 
-func (ctx *Broker) Register(n Node) error {
-	ctx.log().Println("register node", n.NodeId())
+// PublishContext is Publish bounded by c, when c is done before the definition accepts the value
+// the publish fails with ErrPublishTimeout. Synchronous subscriptions see c through Context.Context
+func (ctx *Broker) PublishContext(c context.Context, publisher Node, attr string, value interface{}) error {
+	return ctx.publish(c, publisher.NodeId(), attr, value, nil, nil)
+}
+
+func (ctx *Broker) init() {
 	if ctx.attributes == nil {
 		ctx.attributes = make(map[string]*attributeCtx)
 	}
 	if ctx.subscriptions == nil {
 		ctx.subscriptions = make(map[string]*subscriptionCtx)
 	}
+	if ctx.functions == nil {
+		ctx.functions = make(map[string]*functionCtx)
+	}
+}
+
+// Register adds every attribute, subscription and function of n. Registration is atomic, if anything is invalid
+// or already registered nothing of n is added
+func (ctx *Broker) Register(n Node) error {
+	ctx.log().Println("register node", n.NodeId())
+	return ctx.register(n, n.NodeAttributes(), n.NodeSubscriptions(), functionsOf(n))
+}
+
+// AddAttribute registers a single attribute owned by n and publishes its default value
+func (ctx *Broker) AddAttribute(n Node, attr Attribute) error {
+	return ctx.register(n, []Attribute{attr}, nil, nil)
+}
+
+// Subscribe registers a single subscription owned by n, its id is 'node@name'
+func (ctx *Broker) Subscribe(n Node, sub Subscription) error {
+	return ctx.register(n, nil, []Subscription{sub}, nil)
+}
+
+func (ctx *Broker) register(n Node, attrs []Attribute, subs []Subscription, fns []Function) error {
+	if err := ValidateNodeId(n.NodeId()); err != nil {
+		return err
+	}
 
-	for _, attr := range n.NodeAttributes() {
-		// TODO validate attr.Name
-		// TODO duplicate attr
+	recCtxs := make([]*attributeCtx, 0, len(attrs))
+	defaults := make(map[*attributeCtx]interface{}, len(attrs))
+	for _, attr := range attrs {
+		if err := ValidateName(attr.Name); err != nil {
+			return err
+		}
 		id := fmt.Sprintf("%s.%s", n.NodeId(), attr.Name)
 		if attr.Definition == nil {
 			return fmt.Errorf("definition cannot be nil for attribute:'%s'", id)
 		}
-		ctx.log().Printf("register attribute: '%s' def: '%s'", id, reflect.TypeOf(attr.Definition).Name())
-		ctx.attributes[id] = &attributeCtx{
+		if err := attr.Derive.validate(); err != nil {
+			return fmt.Errorf("%w for attribute:'%s'", err, id)
+		}
+		// the default is recorded once the attribute is committed so it cannot fail then
+		def, err := attr.Definition.ValidateAndTransform(attr.Definition.DefaultValue())
+		if err != nil {
+			return fmt.Errorf("invalid default %w for attribute:'%s'", err, id)
+		}
+		recCtx := &attributeCtx{
 			Attribute: attr,
+			id:        id,
+			node:      n.NodeId(),
+			store:     ctx.Store,
 		}
-		if err := ctx.Publish(n, id, attr.Definition.DefaultValue()); err != nil {
+		defaults[recCtx] = def
+		recCtx.change.flush = func() { ctx.flushHeld(recCtx) }
+		recCtxs = append(recCtxs, recCtx)
+	}
+	// the history is loaded before the attributes become visible so no publish can come before it
+	replayed := make(map[*attributeCtx]bool, len(recCtxs))
+	for _, recCtx := range recCtxs {
+		ok, err := recCtx.replay()
+		if err != nil {
 			return err
 		}
+		if ok {
+			ctx.log().Printf("replayed attribute: '%s' records: %d", recCtx.id, len(recCtx.Records))
+		}
+		replayed[recCtx] = ok
 	}
 
-	for _, sub := range n.NodeSubscriptions() {
-		// TODO validate sub.Name
-		// TODO duplicate sub
+	subCtxs := make(map[string]*subscriptionCtx, len(subs))
+	for _, sub := range subs {
+		if err := ValidateName(sub.Name); err != nil {
+			return err
+		}
 		id := fmt.Sprintf("%s@%s", n.NodeId(), sub.Name)
-		ctx.log().Printf("register subscription: '%s' filter: '%s'", id, sub.Filter)
-		ctx.subscriptions[id] = &subscriptionCtx{
+		if sub.Fn == nil {
+			return fmt.Errorf("fn cannot be nil for subscription:'%s'", id)
+		}
+		if _, err := ParseFilter(sub.Filter); err != nil {
+			return fmt.Errorf("%w for subscription:'%s'", err, id)
+		}
+		if err := sub.Delivery.validate(); err != nil {
+			return fmt.Errorf("%w for subscription:'%s'", err, id)
+		}
+		if err := sub.Retry.validate(); err != nil {
+			return fmt.Errorf("%w for subscription:'%s'", err, id)
+		}
+		if _, ok := subCtxs[id]; ok {
+			return ErrDuplicateSubscription{Subscription: id}
+		}
+		subCtxs[id] = &subscriptionCtx{
 			Subscription: sub,
+			node:         n.NodeId(),
 		}
 	}
 
+	fnCtxs := make(map[string]*functionCtx, len(fns))
+	for _, fn := range fns {
+		if err := ValidateName(fn.Name); err != nil {
+			return err
+		}
+		id := fmt.Sprintf("%s.%s", n.NodeId(), fn.Name)
+		if fn.Fn == nil {
+			return fmt.Errorf("fn cannot be nil for function:'%s'", id)
+		}
+		for _, arg := range fn.Args {
+			if arg.Definition == nil {
+				return fmt.Errorf("definition cannot be nil for argument:'%s' of function:'%s'", arg.Name, id)
+			}
+		}
+		if _, ok := fnCtxs[id]; ok {
+			return ErrDuplicateFunction{Function: id}
+		}
+		fnCtxs[id] = &functionCtx{
+			Function: fn,
+			id:       id,
+			node:     n.NodeId(),
+		}
+	}
+
+	ctx.lock.Lock()
+	ctx.init()
+	initial := make([]*ValueRecord, len(recCtxs))
+	seen := make(map[string]bool, len(recCtxs))
+	for _, recCtx := range recCtxs {
+		if _, ok := ctx.attributes[recCtx.id]; ok || seen[recCtx.id] {
+			ctx.lock.Unlock()
+			return ErrDuplicateAttribute{Attribute: recCtx.id}
+		}
+		seen[recCtx.id] = true
+	}
+	for id := range subCtxs {
+		if _, ok := ctx.subscriptions[id]; ok {
+			ctx.lock.Unlock()
+			return ErrDuplicateSubscription{Subscription: id}
+		}
+	}
+	for id := range fnCtxs {
+		if _, ok := ctx.functions[id]; ok {
+			ctx.lock.Unlock()
+			return ErrDuplicateFunction{Function: id}
+		}
+	}
+	for id, fnCtx := range fnCtxs {
+		ctx.log().Printf("register function: '%s'", id)
+		ctx.functions[id] = fnCtx
+		ctx.functionIndex.insert(id, id)
+	}
+	for i, recCtx := range recCtxs {
+		ctx.log().Printf("register attribute: '%s' type: '%s'", recCtx.id, SchemaOf(recCtx.Attribute.Definition).Type)
+		if err := recCtx.setRetention(ctx.retentionFor(recCtx.id, recCtx.Attribute)); err != nil {
+			ctx.log().Printf("error retention attribute:'%s' err: %s", recCtx.id, err)
+		}
+		// the default is recorded along with the attribute so a concurrent publish always comes after it
+		if !replayed[recCtx] && recCtx.Attribute.Derive.isZero() {
+			initial[i] = ctx.appendDefault(recCtx, defaults[recCtx])
+		}
+		ctx.attributes[recCtx.id] = recCtx
+		ctx.attributeIndex.insert(recCtx.id, recCtx.id)
+		for _, filter := range recCtx.Attribute.Derive.Inputs {
+			ctx.derivedIndex.insert(filter, recCtx.id)
+		}
+	}
+	snapshots := make(map[string][]snapshotEntry)
+	for id, subCtx := range subCtxs {
+		ctx.log().Printf("register subscription: '%s' filter: '%s'", id, subCtx.Filter)
+		if subCtx.Delivery.Async {
+			subCtx.queue = newDeliveryQueue(subCtx.Delivery)
+			go ctx.worker(id, subCtx)
+		}
+		if subCtx.Snapshot {
+			snapshots[id] = ctx.takeSnapshot(subCtx)
+		}
+		ctx.subscriptions[id] = subCtx
+		ctx.subscriptionIndex.insert(subCtx.Filter, id)
+	}
+	ctx.lock.Unlock()
+
+	// the snapshots go out before the defaults below which are live values to the new subscriptions
+	snapshotIds := make(map[string]bool, len(snapshots))
+	for id := range snapshots {
+		snapshotIds[id] = true
+	}
+	for _, id := range sortedIds(snapshotIds) {
+		ctx.deliverSnapshot(id, subCtxs[id], snapshots[id])
+	}
+
+	// defaults are fanned out after the lock is released since subscriptions may publish, nothing
+	// from here on can fail the register. Every default takes its turn before any subscription is
+	// called, one publishing to an attribute of the node would wait on the turn of its default otherwise
+	syncTargets := make([][]fanoutTarget, len(initial))
+	for i, rec := range initial {
+		if rec != nil {
+			syncTargets[i] = ctx.fanoutInTurn(context.Background(), n.NodeId(), recCtxs[i], rec)
+		}
+	}
+	for i, rec := range initial {
+		if rec != nil {
+			ctx.fanoutSync(context.Background(), n.NodeId(), recCtxs[i], rec, syncTargets[i])
+		}
+	}
+	for _, recCtx := range recCtxs {
+		if !recCtx.Attribute.Derive.isZero() {
+			ctx.initializeDerived(n, recCtx, replayed[recCtx])
+		}
+	}
+	return nil
+}
+
+// appendDefault records the default of a newly registered attribute, def is the transformed default.
+// Lock held
+func (ctx *Broker) appendDefault(recCtx *attributeCtx, def interface{}) *ValueRecord {
+	v := Value{
+		AttributeID: recCtx.id,
+		UpdatedBy:   recCtx.node,
+		Value:       def,
+		Unit:        unitOf(recCtx.Attribute.Definition),
+		inspected:   recCtx.Attribute.Definition.Inspect(def),
+	}
+	rec, _, _, err := recCtx.append(v)
+	if err != nil {
+		ctx.log().Printf("error store attribute:'%s' publisher:'%s' err: %s", recCtx.id, recCtx.node, err)
+	}
+	if rec != nil {
+		ctx.log().Printf("set attribute:'%s' value:'%s' publisher:'%s'", recCtx.id, rec.Value.Inspect(), rec.UpdatedBy)
+	}
+	return rec
+}
+
+// initializeDerived computes a derived attribute from its inputs, it falls back to its default
+// without inputs unless its history was replayed
+func (ctx *Broker) initializeDerived(n Node, recCtx *attributeCtx, replayed bool) {
+	// unless an input registered alongside it has triggered that already
+	if _, ok := recCtx.latest(); !ok || replayed {
+		ctx.recompute(context.Background(), recCtx, nil)
+	}
+	if _, ok := recCtx.latest(); ok || replayed {
+		return
+	}
+	// the error is logged by publish
+	ctx.Publish(n, recCtx.id, recCtx.Attribute.Definition.DefaultValue())
+}
+
+// RemoveAttribute removes the attribute and its history, publishes to it that are in flight fail with ErrUnknownAttribute
+func (ctx *Broker) RemoveAttribute(attr string) error {
+	ctx.lock.Lock()
+	recCtx, ok := ctx.attributes[attr]
+	if !ok {
+		ctx.lock.Unlock()
+		return ErrUnknownAttribute{Attribute: attr}
+	}
+	ctx.log().Printf("remove attribute: '%s'", attr)
+	ctx.removeAttribute(recCtx)
+	derived := ctx.dependents(attr)
+	ctx.lock.Unlock()
+
+	ctx.rederive(derived)
+	return nil
+}
+
+// removeAttribute must be called with the broker lock held
+func (ctx *Broker) removeAttribute(recCtx *attributeCtx) {
+	recCtx.remove()
+	delete(ctx.attributes, recCtx.id)
+	ctx.attributeIndex.remove(recCtx.id, recCtx.id)
+	for _, filter := range recCtx.Attribute.Derive.Inputs {
+		ctx.derivedIndex.remove(filter, recCtx.id)
+	}
+}
+
+// removeSubscription must be called with the broker lock held
+func (ctx *Broker) removeSubscription(id string, sub *subscriptionCtx) {
+	sub.remove()
+	delete(ctx.subscriptions, id)
+	ctx.subscriptionIndex.remove(sub.Filter, id)
+}
+
+// Unsubscribe removes the subscription with the given id ('node@name'), once it returns the
+// subscription will not be called again although a call that is already running is not interrupted
+func (ctx *Broker) Unsubscribe(id string) error {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+	sub, ok := ctx.subscriptions[id]
+	if !ok {
+		return ErrUnknownSubscription{Subscription: id}
+	}
+	ctx.log().Printf("remove subscription: '%s'", id)
+	ctx.removeSubscription(id, sub)
+	return nil
+}
+
+// Unregister removes every attribute, subscription and function owned by n
+func (ctx *Broker) Unregister(n Node) error {
+	ctx.log().Println("unregister node", n.NodeId())
+	ctx.lock.Lock()
+	var removed []string
+	for id, recCtx := range ctx.attributes {
+		if recCtx.node == n.NodeId() {
+			ctx.log().Printf("remove attribute: '%s'", id)
+			ctx.removeAttribute(recCtx)
+			removed = append(removed, id)
+		}
+	}
+	for id, sub := range ctx.subscriptions {
+		if sub.node == n.NodeId() {
+			ctx.log().Printf("remove subscription: '%s'", id)
+			ctx.removeSubscription(id, sub)
+		}
+	}
+	for id, fnCtx := range ctx.functions {
+		if fnCtx.node == n.NodeId() {
+			ctx.log().Printf("remove function: '%s'", id)
+			ctx.removeFunction(fnCtx)
+		}
+	}
+	var derived []*attributeCtx
+	for _, id := range removed {
+		derived = append(derived, ctx.dependents(id)...)
+	}
+	ctx.lock.Unlock()
+
+	ctx.rederive(derived)
 	return nil
 }
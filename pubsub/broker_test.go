@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"reflect"
+	"sync"
 	"testing"
 	"time"
 )
@@ -113,3 +115,472 @@ func TestBroker(t *testing.T) {
 	fmt.Println()
 	dump(broker)
 }
+
+func TestBrokerConcurrentPublishers(t *testing.T) {
+	const publishers = 16
+	const publishes = 100
+
+	var received int64
+	var lock sync.Mutex
+	sink := BasicNode{
+		ID: "sink",
+		Subscriptions: []Subscription{
+			{
+				Name:   "count",
+				Filter: "src.>",
+				Fn: func(ctx Context, v Value) {
+					lock.Lock()
+					received++
+					lock.Unlock()
+				},
+			},
+		},
+	}
+	src := BasicNode{ID: "src"}
+	for i := 0; i < publishers; i++ {
+		src.Attributes = append(src.Attributes, Attribute{
+			Name:       fmt.Sprintf("a%d", i),
+			Definition: DoubleDefinition{},
+		})
+	}
+
+	broker := &Broker{}
+	if err := broker.Register(sink); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.Register(src); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < publishers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < publishes; j++ {
+				attr := fmt.Sprintf("src.a%d", i)
+				if err := broker.Publish(src, attr, j); err != nil {
+					t.Error(err)
+				}
+				if _, err := broker.Value(attr, time.Now().Add(time.Second)); err != nil {
+					t.Error(err)
+				}
+				broker.Values(">", time.Now())
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if expected := int64(publishers * (publishes + 1)); received != expected {
+		t.Errorf("expected %d fanouts got %d", expected, received)
+	}
+	for i := 0; i < publishers; i++ {
+		rec, err := broker.Value(fmt.Sprintf("src.a%d", i), time.Now().Add(time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Value.Value != float64(publishes-1) {
+			t.Errorf("expected last value %d got %v", publishes-1, rec.Value.Value)
+		}
+		if rec.RecordId != publishes {
+			t.Errorf("expected record id %d got %d", publishes, rec.RecordId)
+		}
+	}
+}
+
+func TestBrokerConcurrentRegister(t *testing.T) {
+	broker := &Broker{}
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			n := BasicNode{
+				ID: fmt.Sprintf("n%d", i),
+				Attributes: []Attribute{
+					{Name: "a", Definition: StringDefinition{}},
+				},
+				Subscriptions: []Subscription{
+					{
+						Name:   "all",
+						Filter: ">",
+						Fn:     func(ctx Context, v Value) {},
+					},
+				},
+			}
+			if err := broker.Register(n); err != nil {
+				t.Error(err)
+			}
+			if err := broker.Publish(n, n.ID+".a", "hello"); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if recs := broker.Values(">", time.Now().Add(time.Second)); len(recs) != 32 {
+		t.Errorf("expected 32 values got %d", len(recs))
+	}
+}
+
+func TestBrokerReentrantPublish(t *testing.T) {
+	n1 := BasicNode{
+		ID: "n1",
+		Attributes: []Attribute{
+			{Name: "in", Definition: DoubleDefinition{}},
+			{Name: "out", Definition: DoubleDefinition{}},
+		},
+		Subscriptions: []Subscription{
+			{
+				Name:   "double",
+				Filter: "n1.in",
+				Fn: func(ctx Context, v Value) {
+					ctx.Error(ctx.Publish("n1.out", v.Value.(float64)*2))
+					if _, err := ctx.Value("n1.out", time.Now().Add(time.Second)); err != nil {
+						ctx.Error(err)
+					}
+				},
+			},
+		},
+	}
+	broker := &Broker{}
+	if err := broker.Register(n1); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if err := broker.Publish(n1, "n1.in", i*j); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("deadlock publishing from inside a subscription")
+	}
+
+	rec, err := broker.Value("n1.in", time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, res := range rec.SubscriptionResponses {
+		if len(res.Err) > 0 {
+			t.Errorf("unexpected subscription errors %v", res.Err)
+		}
+	}
+}
+
+func TestBrokerUnregister(t *testing.T) {
+	var calls int
+	n1 := BasicNode{
+		ID: "n1",
+		Attributes: []Attribute{
+			{Name: "a1", Definition: StringDefinition{}},
+		},
+		Subscriptions: []Subscription{
+			{
+				Name:   "all",
+				Filter: ">",
+				Fn: func(ctx Context, v Value) {
+					calls++
+				},
+			},
+		},
+	}
+	n2 := BasicNode{
+		ID: "n2",
+		Attributes: []Attribute{
+			{Name: "a1", Definition: StringDefinition{}},
+		},
+	}
+	broker := &Broker{}
+	for _, n := range []Node{n1, n2} {
+		if err := broker.Register(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	calls = 0
+
+	if err := broker.Unregister(n1); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.Publish(n1, "n1.a1", "x"); !errors.As(err, &ErrUnknownAttribute{}) {
+		t.Errorf("expected ErrUnknownAttribute got %v", err)
+	}
+	if err := broker.Publish(n2, "n2.a1", "x"); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 0 {
+		t.Errorf("expected no calls to an unregistered subscription got %d", calls)
+	}
+	if err := broker.Unsubscribe("n1@all"); !errors.As(err, &ErrUnknownSubscription{}) {
+		t.Errorf("expected ErrUnknownSubscription got %v", err)
+	}
+}
+
+func TestBrokerUnsubscribe(t *testing.T) {
+	var calls int
+	n1 := BasicNode{
+		ID: "n1",
+		Attributes: []Attribute{
+			{Name: "a1", Definition: StringDefinition{}},
+		},
+	}
+	broker := &Broker{}
+	if err := broker.Register(n1); err != nil {
+		t.Fatal(err)
+	}
+	// a subscription that removes itself from inside its own fanout
+	err := broker.Subscribe(n1, Subscription{
+		Name:   "once",
+		Filter: "n1.a1",
+		Fn: func(ctx Context, v Value) {
+			calls++
+			ctx.Error(broker.Unsubscribe("n1@once"))
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := broker.Publish(n1, "n1.a1", "x"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 call got %d", calls)
+	}
+}
+
+func TestBrokerAddRemoveAttribute(t *testing.T) {
+	n1 := BasicNode{ID: "n1"}
+	broker := &Broker{}
+	if err := broker.AddAttribute(n1, Attribute{Name: "a1", Definition: BooleanDefinition{}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.Publish(n1, "n1.a1", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.RemoveAttribute("n1.a1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := broker.Value("n1.a1", time.Now()); !errors.As(err, &ErrUnknownAttribute{}) {
+		t.Errorf("expected ErrUnknownAttribute got %v", err)
+	}
+	if err := broker.RemoveAttribute("n1.a1"); !errors.As(err, &ErrUnknownAttribute{}) {
+		t.Errorf("expected ErrUnknownAttribute got %v", err)
+	}
+}
+
+func TestBrokerMaxHops(t *testing.T) {
+	mirror := func(to string) func(ctx Context, v Value) {
+		return func(ctx Context, v Value) {
+			ctx.Error(ctx.Publish(to, v.Value))
+		}
+	}
+	n1 := BasicNode{
+		ID: "n1",
+		Attributes: []Attribute{
+			{Name: "a", Definition: StringDefinition{}},
+			{Name: "b", Definition: StringDefinition{}},
+		},
+	}
+	broker := &Broker{MaxHops: 5}
+	if err := broker.Register(n1); err != nil {
+		t.Fatal(err)
+	}
+	broker.Subscribe(n1, Subscription{Name: "a_to_b", Filter: "n1.a", Fn: mirror("n1.b")})
+	broker.Subscribe(n1, Subscription{Name: "b_to_a", Filter: "n1.b", Fn: mirror("n1.a")})
+
+	if err := broker.Publish(n1, "n1.a", "ping"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the root plus 5 hops alternating between a and b, the 6th hop is refused
+	last, err := broker.Value("n1.b", time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.Hops != 5 {
+		t.Fatalf("expected the last record to be 5 hops from the root got %d", last.Hops)
+	}
+	var maxHops ErrMaxHops
+	if len(last.SubscriptionResponses) != 1 || len(last.SubscriptionResponses[0].Err) != 1 ||
+		!errors.As(last.SubscriptionResponses[0].Err[0], &maxHops) {
+		t.Fatalf("expected ErrMaxHops on the last record got %+v", last.SubscriptionResponses)
+	}
+	if maxHops.Hops != 6 || maxHops.CausedBy != last.Ref() {
+		t.Errorf("unexpected error %+v", maxHops)
+	}
+
+	chain, err := broker.CausalChain(last.Ref())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 6 {
+		t.Fatalf("expected a chain of 6 records got %d", len(chain))
+	}
+	root := chain[len(chain)-1]
+	if root.AttributeID != "n1.a" || root.Hops != 0 || !root.CausedBy.IsZero() || root.UpdatedBy != "n1" {
+		t.Errorf("unexpected root %+v", root.Value)
+	}
+	for i, rec := range chain {
+		if rec.Correlation != root.Ref() {
+			t.Errorf("record %s expected correlation %s got %s", rec.Ref(), root.Ref(), rec.Correlation)
+		}
+		if i+1 < len(chain) && rec.CausedBy != chain[i+1].Ref() {
+			t.Errorf("record %s expected to be caused by %s got %s", rec.Ref(), chain[i+1].Ref(), rec.CausedBy)
+		}
+	}
+}
+
+func TestBrokerRegisterDuplicates(t *testing.T) {
+	noop := func(ctx Context, v Value) {}
+	broker := &Broker{}
+	n1 := BasicNode{
+		ID: "n1",
+		Attributes: []Attribute{
+			{Name: "a1", Definition: StringDefinition{}},
+		},
+		Subscriptions: []Subscription{
+			{Name: "s1", Filter: ">", Fn: noop},
+		},
+	}
+	if err := broker.Register(n1); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.Publish(n1, "n1.a1", "original"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a second node with the same id must not clobber the first
+	impostor := BasicNode{
+		ID: "n1",
+		Attributes: []Attribute{
+			{Name: "a2", Definition: StringDefinition{}},
+			{Name: "a1", Definition: StringDefinition{}},
+		},
+	}
+	var dupAttr ErrDuplicateAttribute
+	if err := broker.Register(impostor); !errors.As(err, &dupAttr) || dupAttr.Attribute != "n1.a1" {
+		t.Errorf("expected ErrDuplicateAttribute for n1.a1 got %v", err)
+	}
+	if _, err := broker.Value("n1.a2", time.Now()); !errors.As(err, &ErrUnknownAttribute{}) {
+		t.Errorf("expected the failed registration to leave nothing behind got %v", err)
+	}
+	if rec, _ := broker.Value("n1.a1", time.Now().Add(time.Second)); rec.Inspect() != "original" {
+		t.Errorf("expected the original value to survive got '%s'", rec.Inspect())
+	}
+
+	if err := broker.Subscribe(n1, Subscription{Name: "s1", Filter: ">", Fn: noop}); !errors.As(err, &ErrDuplicateSubscription{}) {
+		t.Errorf("expected ErrDuplicateSubscription got %v", err)
+	}
+
+	twice := BasicNode{
+		ID: "n2",
+		Attributes: []Attribute{
+			{Name: "a1", Definition: StringDefinition{}},
+			{Name: "a1", Definition: StringDefinition{}},
+		},
+	}
+	if err := broker.Register(twice); !errors.As(err, &ErrDuplicateAttribute{}) {
+		t.Errorf("expected ErrDuplicateAttribute got %v", err)
+	}
+	if recs := broker.Values("n2.>", time.Now().Add(time.Second)); len(recs) != 0 {
+		t.Errorf("expected no attributes for n2 got %d", len(recs))
+	}
+}
+
+func TestBrokerRegisterInvalidNames(t *testing.T) {
+	noop := func(ctx Context, v Value) {}
+	broker := &Broker{}
+	for _, n := range []BasicNode{
+		{ID: ""},
+		{ID: "n1.x"},
+		{ID: "n1", Attributes: []Attribute{{Name: "ok", Definition: StringDefinition{}}, {Name: "gpio.*", Definition: StringDefinition{}}}},
+		{ID: "n1", Attributes: []Attribute{{Name: "gpio..0", Definition: StringDefinition{}}}},
+		{ID: "n1", Subscriptions: []Subscription{{Name: ">", Filter: ">", Fn: noop}}},
+	} {
+		if err := broker.Register(n); !errors.As(err, &ErrInvalidName{}) {
+			t.Errorf("node %+v expected ErrInvalidName got %v", n, err)
+		}
+	}
+	if recs := broker.Values(">", time.Now().Add(time.Second)); len(recs) != 0 {
+		t.Errorf("expected nothing to be registered got %d", len(recs))
+	}
+}
+
+func TestBrokerRegisterRollback(t *testing.T) {
+	broker := &Broker{Store: &MemoryStore{}}
+	// a persisted value the definition no longer accepts fails the replay of the second attribute
+	broker.Store.Append(ValueRecord{Value: Value{AttributeID: "n1.b", Value: struct{}{}}})
+	n1 := BasicNode{
+		ID: "n1",
+		Attributes: []Attribute{
+			{Name: "a", Definition: StringDefinition{}},
+			{Name: "b", Definition: StringDefinition{}},
+		},
+		Subscriptions: []Subscription{
+			{Name: "s", Filter: ">", Fn: func(ctx Context, v Value) {}},
+		},
+	}
+	if err := broker.Register(n1); err == nil {
+		t.Fatal("expected the replay to fail")
+	}
+	if recs := broker.Values(">", time.Now().Add(time.Second)); len(recs) != 0 {
+		t.Errorf("expected no attributes after rollback got %d", len(recs))
+	}
+	if err := broker.Unsubscribe("n1@s"); !errors.As(err, &ErrUnknownSubscription{}) {
+		t.Errorf("expected the subscription to be rolled back got %v", err)
+	}
+
+	// an invalid default fails the register before any other default is published
+	var delivered []string
+	watcher := BasicNode{ID: "watcher", Subscriptions: []Subscription{{Name: "all", Filter: ">", Fn: func(ctx Context, v Value) {
+		delivered = append(delivered, v.AttributeID)
+	}}}}
+	if err := broker.Register(watcher); err != nil {
+		t.Fatal(err)
+	}
+	n2 := BasicNode{
+		ID: "n2",
+		Attributes: []Attribute{
+			{Name: "a", Definition: StringDefinition{}},
+			{Name: "b", Definition: EnumDefinition{Values: []string{"on", "off"}, Default: "dim"}},
+		},
+	}
+	if err := broker.Register(n2); !errors.As(err, &ErrInvalidEnumValue{}) {
+		t.Errorf("expected the invalid default to fail the register got %v", err)
+	}
+	if len(delivered) != 0 {
+		t.Errorf("expected no default to be delivered got %v", delivered)
+	}
+	if recs, _ := broker.Store.Load("n2.a"); len(recs) != 0 {
+		t.Errorf("expected no default to be stored got %+v", recs)
+	}
+
+	// once the register succeeds every default is stored and delivered
+	n2.Attributes[1].Definition = EnumDefinition{Values: []string{"on", "off"}, Default: "off"}
+	if err := broker.Register(n2); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(delivered, []string{"n2.a", "n2.b"}) {
+		t.Errorf("expected both defaults to be delivered got %v", delivered)
+	}
+	if recs, _ := broker.Store.Load("n2.b"); len(recs) != 1 || recs[0].Value.Value != "off" {
+		t.Errorf("expected the default to be stored got %+v", recs)
+	}
+}
@@ -0,0 +1,192 @@
+package pubsub
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"time"
+)
+
+// ChangeFilter suppresses updates of an attribute that carry no news, the zero value records every update.
+// Only what the owner reports is filtered, a write from another node is a command that always goes through Accept
+// and is recorded. A suppressed update is neither recorded nor fanned out, its publish fails with ErrSuppressed
+type ChangeFilter struct {
+	// OnlyChanges suppresses a value equal to the last recorded one
+	OnlyChanges bool
+	// Deadband suppresses a numeric value that moved at most Deadband away from the last recorded one
+	Deadband float64
+	// DeadbandPercent is Deadband as a percentage of the last recorded value
+	DeadbandPercent float64
+	// MinInterval holds values arriving sooner than MinInterval after the last record,
+	// the latest held value is recorded once the interval is over
+	MinInterval time.Duration
+	// MaxSilence records the latest suppressed value once MaxSilence has passed since the last record,
+	// so a value creeping along inside the deadband is not hidden forever
+	MaxSilence time.Duration
+}
+
+type SuppressReason int
+
+const (
+	NotSuppressed SuppressReason = iota
+	SuppressUnchanged
+	SuppressDeadband
+	SuppressInterval
+)
+
+func (r SuppressReason) String() string {
+	switch r {
+	case NotSuppressed:
+		return "none"
+	case SuppressUnchanged:
+		return "unchanged"
+	case SuppressDeadband:
+		return "deadband"
+	case SuppressInterval:
+		return "interval"
+	}
+	return "unknown"
+}
+
+// SuppressionStats counts the updates a ChangeFilter suppressed since the attribute was registered
+type SuppressionStats struct {
+	Total     int
+	Unchanged int
+	Deadband  int
+	Interval  int
+	// Pending is set while a held value waits for MinInterval or MaxSilence to be recorded
+	Pending bool
+}
+
+type changeState struct {
+	// suppressed counts updates suppressed since the last record
+	suppressed int
+	stats      SuppressionStats
+	held       *Value
+	flushAt    time.Time
+	flushTimer *time.Timer
+	// flush records the held value, it is set by the broker at register time
+	flush func()
+}
+
+func (f ChangeFilter) isZero() bool {
+	return !f.OnlyChanges && f.Deadband <= 0 && f.DeadbandPercent <= 0 && f.MinInterval <= 0
+}
+
+// suppressReason decides if v is suppressed given the last record, lock held
+func (ctx *attributeCtx) suppressReason(v Value, now time.Time) SuppressReason {
+	f := ctx.Attribute.Change
+	if f.isZero() || len(ctx.Records) == 0 || !isOwner(v.AttributeID, v.UpdatedBy) {
+		return NotSuppressed
+	}
+	last := ctx.Records[len(ctx.Records)-1]
+	elapsed := now.Sub(last.UpdatedAt)
+	if f.MaxSilence > 0 && elapsed >= f.MaxSilence {
+		return NotSuppressed
+	}
+	if f.OnlyChanges && reflect.DeepEqual(last.Value.Value, v.Value) {
+		return SuppressUnchanged
+	}
+	if isNumeric(ctx.Attribute.Definition) && (f.Deadband > 0 || f.DeadbandPercent > 0) {
+		prev, next := toFloat(last.Value.Value), toFloat(v.Value)
+		delta := math.Abs(next - prev)
+		if (f.Deadband > 0 && delta <= f.Deadband) || (f.DeadbandPercent > 0 && delta <= math.Abs(prev)*f.DeadbandPercent/100) {
+			return SuppressDeadband
+		}
+	}
+	if f.MinInterval > 0 && elapsed < f.MinInterval {
+		return SuppressInterval
+	}
+	return NotSuppressed
+}
+
+// suppress holds v and schedules the flush of the held value if needed, lock held
+func (ctx *attributeCtx) suppress(v Value, reason SuppressReason, now time.Time) {
+	ctx.change.suppressed++
+	ctx.change.stats.Total++
+	switch reason {
+	case SuppressUnchanged:
+		ctx.change.stats.Unchanged++
+	case SuppressDeadband:
+		ctx.change.stats.Deadband++
+	case SuppressInterval:
+		ctx.change.stats.Interval++
+	}
+	ctx.change.held = &v
+
+	last := ctx.Records[len(ctx.Records)-1].UpdatedAt
+	var at time.Time
+	if reason == SuppressInterval {
+		at = last.Add(ctx.Attribute.Change.MinInterval)
+	}
+	if silence := ctx.Attribute.Change.MaxSilence; silence > 0 && (at.IsZero() || last.Add(silence).Before(at)) {
+		at = last.Add(silence)
+	}
+	if at.IsZero() || ctx.change.flush == nil {
+		return
+	}
+	if ctx.change.flushTimer == nil {
+		ctx.change.flushAt = at
+		ctx.change.flushTimer = time.AfterFunc(at.Sub(now), ctx.change.flush)
+	} else if at.Before(ctx.change.flushAt) {
+		ctx.change.flushAt = at
+		ctx.change.flushTimer.Reset(at.Sub(now))
+	}
+}
+
+// recorded forgets the held value once a newer value made it into the history, lock held
+func (ctx *attributeCtx) recorded(rec *ValueRecord) {
+	rec.Suppressed = ctx.change.suppressed
+	ctx.change.suppressed = 0
+	ctx.change.held = nil
+	if ctx.change.flushTimer != nil {
+		ctx.change.flushTimer.Stop()
+		ctx.change.flushTimer = nil
+	}
+}
+
+// takeHeld hands out the held value for flushing, it does not count as suppressed anymore
+func (ctx *attributeCtx) takeHeld() (Value, bool) {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+	ctx.change.flushTimer = nil
+	if ctx.change.held == nil || ctx.removed {
+		return Value{}, false
+	}
+	v := *ctx.change.held
+	ctx.change.held = nil
+	ctx.change.suppressed--
+	return v, true
+}
+
+func (ctx *Broker) flushHeld(recCtx *attributeCtx) {
+	v, ok := recCtx.takeHeld()
+	if !ok {
+		return
+	}
+	rec, reason, ok, storeErr := recCtx.append(v)
+	if !ok || rec == nil {
+		if reason != NotSuppressed {
+			ctx.log().Printf("suppressed held attribute:'%s' value:'%s' reason:'%s'", v.AttributeID, v.Inspect(), reason)
+		}
+		return
+	}
+	if storeErr != nil {
+		ctx.log().Printf("error store attribute:'%s' publisher:'%s' err: %s", v.AttributeID, v.UpdatedBy, storeErr)
+	}
+	ctx.log().Printf("set held attribute:'%s' value:'%s' publisher:'%s'", v.AttributeID, rec.Value.Inspect(), rec.UpdatedBy)
+	ctx.fanout(context.Background(), v.UpdatedBy, recCtx, rec)
+}
+
+// Suppression reports what the ChangeFilter of attr has suppressed so far
+func (ctx *Broker) Suppression(attr string) (SuppressionStats, error) {
+	recCtx, ok := ctx.attribute(attr)
+	if !ok {
+		return SuppressionStats{}, ErrUnknownAttribute{Attribute: attr}
+	}
+	recCtx.lock.RLock()
+	defer recCtx.lock.RUnlock()
+	stats := recCtx.change.stats
+	stats.Pending = recCtx.change.held != nil && recCtx.change.flushTimer != nil
+	return stats, nil
+}
@@ -0,0 +1,196 @@
+package pubsub
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestChangeFilter(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Filter   ChangeFilter
+		Values   []interface{}
+		Recorded []float64
+		Stats    SuppressionStats
+	}{
+		{"zero", ChangeFilter{}, []interface{}{1.0, 1.0, 1.0}, []float64{0, 1, 1, 1}, SuppressionStats{}},
+		{"only changes", ChangeFilter{OnlyChanges: true}, []interface{}{1.0, 1.0, "1", 2.0, 1.0},
+			[]float64{0, 1, 2, 1}, SuppressionStats{Total: 2, Unchanged: 2}},
+		{"deadband", ChangeFilter{Deadband: 0.5}, []interface{}{10.0, 10.4, 10.5, 10.51, 11.0, 12.0},
+			[]float64{0, 10, 10.51, 12}, SuppressionStats{Total: 3, Deadband: 3}},
+		{"deadband percent", ChangeFilter{DeadbandPercent: 10}, []interface{}{100.0, 109.0, 90.0, 89.0, 80.2, 80.0},
+			[]float64{0, 100, 89, 80}, SuppressionStats{Total: 3, Deadband: 3}},
+		{"both deadbands", ChangeFilter{Deadband: 1, DeadbandPercent: 1}, []interface{}{1000.0, 1005.0, 1011.0, 1011.5, 1030.0},
+			[]float64{0, 1000, 1011, 1030}, SuppressionStats{Total: 2, Deadband: 2}},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var fanout int
+			broker := &Broker{}
+			node := BasicNode{
+				ID:         "sensor",
+				Attributes: []Attribute{{Name: "temp", Definition: DoubleDefinition{}, Change: test.Filter}},
+				Subscriptions: []Subscription{{Name: "watch", Filter: "sensor.temp", Fn: func(ctx Context, v Value) {
+					fanout++
+				}}},
+			}
+			if err := broker.Register(node); err != nil {
+				t.Fatal(err)
+			}
+			for _, v := range test.Values {
+				if err := broker.Publish(node, "sensor.temp", v); err != nil && !errors.As(err, &ErrSuppressed{}) {
+					t.Fatal(err)
+				}
+			}
+			recs := broker.History("sensor.temp", time.Time{}, time.Now().Add(time.Second))
+			var recorded []float64
+			suppressed := 0
+			for _, rec := range recs {
+				recorded = append(recorded, rec.Value.Value.(float64))
+				suppressed += rec.Suppressed
+			}
+			if len(recorded) != len(test.Recorded) {
+				t.Fatalf("expected %v got %v", test.Recorded, recorded)
+			}
+			for i := range recorded {
+				if recorded[i] != test.Recorded[i] {
+					t.Fatalf("expected %v got %v", test.Recorded, recorded)
+				}
+			}
+			if fanout != len(recorded) {
+				t.Errorf("expected %d fanouts got %d", len(recorded), fanout)
+			}
+			stats, err := broker.Suppression("sensor.temp")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats != test.Stats {
+				t.Errorf("expected %+v got %+v", test.Stats, stats)
+			}
+			// every case ends with a recorded value so each suppression is counted by a record
+			if suppressed != stats.Total {
+				t.Errorf("expected records to count %d suppressed got %d", stats.Total, suppressed)
+			}
+		})
+	}
+}
+
+func TestChangeFilterMinInterval(t *testing.T) {
+	var lock sync.Mutex
+	var seen []float64
+	broker := &Broker{}
+	node := BasicNode{
+		ID: "sensor",
+		Attributes: []Attribute{{Name: "temp", Definition: DoubleDefinition{},
+			Change: ChangeFilter{MinInterval: 100 * time.Millisecond}}},
+		Subscriptions: []Subscription{{Name: "watch", Filter: "sensor.temp", Fn: func(ctx Context, v Value) {
+			lock.Lock()
+			seen = append(seen, v.Value.(float64))
+			lock.Unlock()
+		}}},
+	}
+	if err := broker.Register(node); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []float64{1, 2, 3} {
+		if err := broker.Publish(node, "sensor.temp", v); !errors.As(err, &ErrSuppressed{Reason: SuppressInterval}) {
+			t.Fatalf("expected the value to be held got %v", err)
+		}
+	}
+	if stats, _ := broker.Suppression("sensor.temp"); stats.Interval != 3 || !stats.Pending {
+		t.Errorf("expected three held values got %+v", stats)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	lock.Lock()
+	if len(seen) != 2 || seen[1] != 3 {
+		t.Errorf("expected only the latest held value to be recorded got %v", seen)
+	}
+	lock.Unlock()
+	rec, err := broker.Value("sensor.temp", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Value.Value != 3.0 || rec.Suppressed != 2 {
+		t.Errorf("expected 3 recorded after two suppressed values got %v suppressed %d", rec.Value.Value, rec.Suppressed)
+	}
+	if stats, _ := broker.Suppression("sensor.temp"); stats.Pending {
+		t.Errorf("expected nothing pending after the flush got %+v", stats)
+	}
+}
+
+func TestChangeFilterMaxSilence(t *testing.T) {
+	broker := &Broker{}
+	node := BasicNode{
+		ID: "sensor",
+		Attributes: []Attribute{{Name: "temp", Definition: DoubleDefinition{},
+			Change: ChangeFilter{Deadband: 1, MaxSilence: 100 * time.Millisecond}}},
+	}
+	if err := broker.Register(node); err != nil {
+		t.Fatal(err)
+	}
+	var suppressed ErrSuppressed
+	if err := broker.Publish(node, "sensor.temp", 0.5); !errors.As(err, &suppressed) || suppressed.Reason != SuppressDeadband {
+		t.Fatalf("expected the deadband to suppress the update got %v", err)
+	}
+	if rec, _ := broker.Value("sensor.temp", time.Now()); rec.RecordId != 0 {
+		t.Fatalf("expected the update to be suppressed got record %d", rec.RecordId)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	rec, err := broker.Value("sensor.temp", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.RecordId != 1 || rec.Value.Value != 0.5 {
+		t.Errorf("expected the suppressed value to be recorded after MaxSilence got %d %v", rec.RecordId, rec.Value.Value)
+	}
+
+	// an unchanged value is recorded right away once the attribute was silent long enough
+	time.Sleep(150 * time.Millisecond)
+	if err := broker.Publish(node, "sensor.temp", 0.5); err != nil {
+		t.Fatal(err)
+	}
+	if rec, _ := broker.Value("sensor.temp", time.Now()); rec.RecordId != 2 {
+		t.Errorf("expected a record after MaxSilence got %d", rec.RecordId)
+	}
+}
+
+func TestChangeFilterWrites(t *testing.T) {
+	var accepted []bool
+	broker := &Broker{}
+	device := BasicNode{
+		ID: "device",
+		Attributes: []Attribute{{Name: "relay", Change: ChangeFilter{OnlyChanges: true},
+			Definition: BooleanDefinition{AcceptFn: func(v bool) error {
+				accepted = append(accepted, v)
+				return nil
+			}}}},
+	}
+	ui := BasicNode{ID: "ui"}
+	for _, n := range []BasicNode{device, ui} {
+		if err := broker.Register(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the owner reporting the state it already has is suppressed and told why
+	var suppressed ErrSuppressed
+	if err := broker.Publish(device, "device.relay", false); !errors.As(err, &suppressed) || suppressed.Reason != SuppressUnchanged {
+		t.Errorf("expected the unchanged report to be suppressed got %v", err)
+	}
+	// a write from another node reaches the device and is recorded even when it repeats the last value
+	for i := 0; i < 2; i++ {
+		if err := broker.Publish(ui, "device.relay", false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(accepted) != 2 {
+		t.Errorf("expected both writes to be accepted got %v", accepted)
+	}
+	if recs := broker.History("device.relay", time.Time{}, time.Now().Add(time.Second)); len(recs) != 3 {
+		t.Errorf("expected the default and both writes to be recorded got %d records", len(recs))
+	}
+}
@@ -2,13 +2,18 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"github.com/pborges/iot/espiot"
 	"github.com/pborges/iot/pubsub"
 	"log"
 	"net"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -64,9 +69,19 @@ func discoverAndHandle(broker *pubsub.Broker) {
 			node.Attributes = append(node.Attributes, attr)
 		}
 
-		dev.OnUpdate(func(v espiot.AttributeAndValue) {
+		for _, f := range dev.ListFunctions() {
+			fn, err := deviceFunction(dev, f)
+			if err != nil {
+				// a function named or typed in a way the broker cannot take is skipped, the rest of the device still registers
+				log.Println("error function", dev.Id(), f.Name, err)
+				continue
+			}
+			node.Functions = append(node.Functions, fn)
+		}
+
+		dev.OnUpdate(func(dev *espiot.Device, v espiot.AttributeAndValue) {
 			id := fmt.Sprintf("%s.%s", dev.Id(), v.AttributeDef().Name)
-			if err := broker.Publish(node, id, v.InspectValue()); err != nil {
+			if err := broker.Publish(node, id, v.InspectValue()); err != nil && !errors.As(err, &pubsub.ErrSuppressed{}) {
 				log.Println("error publishing", id, v.InspectValue(), err)
 			}
 		})
@@ -79,10 +94,57 @@ func discoverAndHandle(broker *pubsub.Broker) {
 	}
 }
 
+// deviceFunction exposes a function of the device, it fails when an argument has a type it cannot map
+func deviceFunction(dev *espiot.Device, f espiot.Function) (pubsub.Function, error) {
+	fn := pubsub.Function{Name: f.Name}
+	if err := pubsub.ValidateName(f.Name); err != nil {
+		return fn, err
+	}
+	for _, a := range f.Args {
+		def, err := argDefinition(a.Type)
+		if err != nil {
+			return fn, fmt.Errorf("argument %s: %w", a.Name, err)
+		}
+		fn.Args = append(fn.Args, pubsub.Field{Name: a.Name, Definition: def, Required: true})
+	}
+	// the device protocol does not declare a result
+	fn.Fn = func(ctx pubsub.Context, args map[string]interface{}) (interface{}, error) {
+		_, err := dev.Call(f.Name, args)
+		return nil, err
+	}
+	return fn, nil
+}
+
+// argDefinition maps the type of a device function argument, they are named like the attribute types
+func argDefinition(t string) (pubsub.Definition, error) {
+	switch t {
+	case "string":
+		return pubsub.StringDefinition{}, nil
+	case "bool":
+		return pubsub.BooleanDefinition{}, nil
+	case "integer":
+		return pubsub.IntegerDefinition{}, nil
+	case "double":
+		return pubsub.DoubleDefinition{}, nil
+	}
+	return nil, errors.New("unknown argument type: " + t)
+}
+
 func main() {
+	storePath := flag.String("store", "broker.log", "file the broker history is persisted to, empty to keep it in memory")
+	flag.Parse()
+
 	broker := &pubsub.Broker{
 		Log: log.New(os.Stdout, "[BROKER] ", log.LstdFlags),
 	}
+	if *storePath != "" {
+		store, err := pubsub.OpenFileStore(*storePath)
+		if err != nil {
+			log.Fatalln("error opening store", *storePath, err)
+		}
+		defer store.Close()
+		broker.Store = store
+	}
 
 	go discoverAndHandle(broker)
 
@@ -106,24 +168,169 @@ func handleConnection(broker *pubsub.Broker, conn net.Conn) {
 	for scanner.Scan() {
 		in <- scanner.Text()
 	}
+	close(in)
+}
+
+// publishTimeout bounds a pub or call command, it covers waiting on another client to accept the value
+const publishTimeout = 30 * time.Second
+
+// schemaFromArgs builds a schema from the arguments of a def command, e.g.
+// def name:temp type:double unit:°C min:10 max:30 step:0.5 precision:1 policy:clamp coercion:strict
+// def name:mode type:enum values:off,heat,cool default:off
+// def name:color type:object fields:r=integer,g=integer,b=integer
+// def name:tags type:array element:string min_length:1 max_length:4
+// def name:timeout type:duration unit:1ms
+func schemaFromArgs(args map[string]string) (pubsub.Schema, error) {
+	s := pubsub.Schema{Type: args["type"], Coercion: args["coercion"], Unit: args["unit"], Policy: args["policy"]}
+	var err error
+	parseFloat := func(key string) *float64 {
+		if args[key] == "" || err != nil {
+			return nil
+		}
+		var f float64
+		if f, err = strconv.ParseFloat(args[key], 64); err != nil {
+			err = fmt.Errorf("invalid %s: %w", key, err)
+		}
+		return &f
+	}
+	parseInt := func(key string) int {
+		if args[key] == "" || err != nil {
+			return 0
+		}
+		var i int
+		if i, err = strconv.Atoi(args[key]); err != nil {
+			err = fmt.Errorf("invalid %s: %w", key, err)
+		}
+		return i
+	}
+	s.Min = parseFloat("min")
+	s.Max = parseFloat("max")
+	if step := parseFloat("step"); step != nil {
+		s.Step = *step
+	}
+	s.Precision = parseInt("precision")
+	s.MinLength = parseInt("min_length")
+	s.MaxLength = parseInt("max_length")
+	if err != nil {
+		return s, err
+	}
+	if args["values"] != "" {
+		s.Values = strings.Split(args["values"], ",")
+	}
+	if args["default"] != "" {
+		s.Default = args["default"]
+	}
+	if args["fields"] != "" {
+		for _, f := range strings.Split(args["fields"], ",") {
+			kv := strings.SplitN(f, "=", 2)
+			if len(kv) != 2 {
+				return s, fmt.Errorf("invalid field '%s' expected name=type", f)
+			}
+			s.Fields = append(s.Fields, pubsub.FieldSchema{Name: kv[0], Schema: pubsub.Schema{Type: kv[1]}})
+		}
+	}
+	if args["element"] != "" {
+		s.Element = &pubsub.Schema{Type: args["element"]}
+	}
+	return s, nil
+}
+
+func parseDurations(args map[string]string, into map[string]*time.Duration) error {
+	for key, d := range into {
+		if args[key] == "" {
+			continue
+		}
+		v, err := time.ParseDuration(args[key])
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", key, err)
+		}
+		*d = v
+	}
+	return nil
+}
+
+func conditionFromArgs(args map[string]string) (pubsub.Condition, error) {
+	var c pubsub.Condition
+	c.Changed = args["changed"] == "true"
+	if v, ok := args["from"]; ok {
+		c.ChangedFrom = v
+	}
+	if v, ok := args["to"]; ok {
+		c.ChangedTo = v
+	}
+	var hysteresis float64
+	if args["hysteresis"] != "" {
+		h, err := strconv.ParseFloat(args["hysteresis"], 64)
+		if err != nil {
+			return c, fmt.Errorf("invalid hysteresis: %w", err)
+		}
+		hysteresis = h
+	}
+	for key, t := range map[string]**pubsub.Threshold{"rising": &c.Rising, "falling": &c.Falling} {
+		if args[key] == "" {
+			continue
+		}
+		level, err := strconv.ParseFloat(args[key], 64)
+		if err != nil {
+			return c, fmt.Errorf("invalid %s: %w", key, err)
+		}
+		*t = &pubsub.Threshold{Level: level, Hysteresis: hysteresis}
+	}
+	switch args["edge"] {
+	case "":
+	case "rising":
+		c.Edge = pubsub.RisingEdge
+	case "falling":
+		c.Edge = pubsub.FallingEdge
+	case "any":
+		c.Edge = pubsub.AnyEdge
+	default:
+		return c, fmt.Errorf("invalid edge: %s", args["edge"])
+	}
+	return c, nil
+}
+
+type acceptRequest struct {
+	value string
+	res   chan string
 }
 
 func process(broker *pubsub.Broker, conn net.Conn, in chan string) {
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
 	fmt.Fprint(conn, "node: ")
 
+	id, ok := <-in
+	if !ok {
+		return
+	}
+	if err := pubsub.ValidateNodeId(id); err != nil {
+		fmt.Fprintln(conn, "err", err)
+		return
+	}
 	node := pubsub.BasicNode{
-		ID: <-in,
+		ID: id,
 	}
+	defer broker.Unregister(node)
 
 	fmt.Fprintf(conn, "Welcome %s!\n", node.ID)
-	acceptFn := make(chan string)
+	accepts := make(chan acceptRequest)
 	for {
 		select {
-		case value := <-acceptFn:
-			fmt.Fprint(conn, "PUB value:'"+value+"' Press enter to accept, or type an error: ")
-			acceptFn <- <-in
+		case req := <-accepts:
+			fmt.Fprint(conn, "PUB value:'"+req.value+"' Press enter to accept, or type an error: ")
+			answer, ok := <-in
+			if !ok {
+				req.res <- "node disconnected"
+				return
+			}
+			req.res <- answer
 			fmt.Fprintln(conn, "ok")
-		case line := <-in:
+		case line, ok := <-in:
+			if !ok {
+				return
+			}
 			packet, err := espiot.Decode(line)
 			if err != nil {
 				fmt.Fprintln(conn, err)
@@ -136,7 +343,10 @@ func process(broker *pubsub.Broker, conn net.Conn, in chan string) {
 				}
 				fmt.Fprintln(conn, "ok")
 			case "pub":
-				if err := broker.Publish(node, packet.Args["name"], packet.Args["value"]); err == nil {
+				ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+				err := broker.PublishContext(ctx, node, packet.Args["name"], packet.Args["value"])
+				cancel()
+				if err == nil {
 					fmt.Fprintln(conn, "ok")
 				} else {
 					fmt.Fprintln(conn, "err", err)
@@ -145,6 +355,34 @@ func process(broker *pubsub.Broker, conn net.Conn, in chan string) {
 				var sub pubsub.Subscription
 				sub.Name = packet.Args["name"]
 				sub.Filter = packet.Args["filter"]
+				// snapshot:true starts the subscription with the current value of every matching attribute
+				sub.Snapshot = packet.Args["snapshot"] == "true"
+				// a slow client only ever holds up its own subscriptions
+				sub.Delivery = pubsub.Delivery{Async: true, Overflow: pubsub.OverflowDropOldest}
+				// throttle:1s, debounce:500ms or sample:10s pace every matched attribute on its own
+				// retries:3 backoff:1s max_backoff:30s deadletter:node.attr retry values the client reported an error for
+				if err := parseDurations(packet.Args, map[string]*time.Duration{
+					"throttle":    &sub.Delivery.Throttle,
+					"debounce":    &sub.Delivery.Debounce,
+					"sample":      &sub.Delivery.Sample,
+					"backoff":     &sub.Retry.Backoff,
+					"max_backoff": &sub.Retry.MaxBackoff,
+				}); err != nil {
+					fmt.Fprintln(conn, "err", err)
+					continue
+				}
+				if packet.Args["retries"] != "" {
+					if sub.Retry.MaxAttempts, err = strconv.Atoi(packet.Args["retries"]); err != nil {
+						fmt.Fprintln(conn, "err invalid retries:", err)
+						continue
+					}
+				}
+				sub.Retry.DeadLetter = packet.Args["deadletter"]
+				// changed:true, from:x, to:y, rising:30, falling:10, hysteresis:1 or edge:rising|falling|any
+				if sub.When, err = conditionFromArgs(packet.Args); err != nil {
+					fmt.Fprintln(conn, "err", err)
+					continue
+				}
 				sub.Fn = func(ctx pubsub.Context, v pubsub.Value) {
 					fmt.Fprintf(conn, "SUB[%s] attribute: %s value: %s published by %s @ %s\n",
 						sub.Name,
@@ -154,30 +392,141 @@ func process(broker *pubsub.Broker, conn net.Conn, in chan string) {
 						v.UpdatedAt.Local().Format(time.RubyDate),
 					)
 				}
+				if err := broker.Subscribe(node, sub); err != nil {
+					fmt.Fprintln(conn, "err", err)
+					continue
+				}
 				node.Subscriptions = append(node.Subscriptions, sub)
-				broker.Register(node)
+				fmt.Fprintln(conn, "ok")
+			case "unsub":
+				if err := broker.Unsubscribe(node.ID + "@" + packet.Args["name"]); err != nil {
+					fmt.Fprintln(conn, "err", err)
+					continue
+				}
+				for i, sub := range node.Subscriptions {
+					if sub.Name == packet.Args["name"] {
+						node.Subscriptions = append(node.Subscriptions[:i], node.Subscriptions[i+1:]...)
+						break
+					}
+				}
 				fmt.Fprintln(conn, "ok")
 			case "def":
 				var attr pubsub.Attribute
 				attr.Name = packet.Args["name"]
-				switch packet.Args["type"] {
-				case "string":
-					def := pubsub.StringDefinition{}
-					def.AcceptFn = func(v string) error {
-						acceptFn <- v
-						err := <-acceptFn
+				schema, err := schemaFromArgs(packet.Args)
+				if err != nil {
+					fmt.Fprintln(conn, "err", err)
+					continue
+				}
+				if packet.Args["access"] != "" {
+					if err := attr.Access.UnmarshalText([]byte(packet.Args["access"])); err != nil {
+						fmt.Fprintln(conn, "err", err)
+						continue
+					}
+				}
+				// the value is handed to this client which accepts it with an empty line or rejects it with an error
+				acceptFn := func(ctx context.Context, v interface{}) error {
+					req := acceptRequest{value: attr.Definition.Inspect(v), res: make(chan string, 1)}
+					select {
+					case accepts <- req:
+					case <-done:
+						return errors.New("node disconnected")
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+					select {
+					case err := <-req.res:
 						if err != "" {
 							return errors.New(err)
 						}
 						return nil
+					case <-ctx.Done():
+						return ctx.Err()
 					}
-					attr.Definition = def
-				default:
-					fmt.Fprintln(conn, "unknown type")
+				}
+				if attr.Definition, err = schema.Definition(acceptFn); err != nil {
+					fmt.Fprintln(conn, "err", err)
+					continue
+				}
+				if err := broker.AddAttribute(node, attr); err != nil {
+					fmt.Fprintln(conn, "err", err)
 					continue
 				}
 				node.Attributes = append(node.Attributes, attr)
-				broker.Register(node)
+				fmt.Fprintln(conn, "ok")
+			case "describe":
+				filter := packet.Args["filter"]
+				if filter == "" {
+					filter = ">"
+				}
+				for _, d := range broker.Describe(filter) {
+					b, err := json.Marshal(d)
+					if err != nil {
+						fmt.Fprintln(conn, "err", err)
+						continue
+					}
+					fmt.Fprintln(conn, string(b))
+				}
+				fmt.Fprintln(conn, "ok")
+			case "funcs":
+				filter := packet.Args["filter"]
+				if filter == "" {
+					filter = ">"
+				}
+				for _, f := range broker.Functions(filter) {
+					b, err := json.Marshal(f)
+					if err != nil {
+						fmt.Fprintln(conn, "err", err)
+						continue
+					}
+					fmt.Fprintln(conn, string(b))
+				}
+				fmt.Fprintln(conn, "ok")
+			case "call":
+				// call func:dev.reboot delay:5, every other arg is an argument of the function
+				args := make(map[string]interface{})
+				for k, v := range packet.Args {
+					if k != "func" {
+						args[k] = v
+					}
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+				result, err := broker.CallContext(ctx, node, packet.Args["func"], args)
+				cancel()
+				if err != nil {
+					fmt.Fprintln(conn, "err", err)
+					continue
+				}
+				if result != nil {
+					fmt.Fprintf(conn, "result: %v\n", result)
+				}
+				fmt.Fprintln(conn, "ok")
+			case "options":
+				def, err := broker.Definition(packet.Args["name"])
+				if err != nil {
+					fmt.Fprintln(conn, "err", err)
+					continue
+				}
+				enum, ok := def.(pubsub.EnumDefinition)
+				if !ok {
+					fmt.Fprintln(conn, "err not an enum")
+					continue
+				}
+				for i, option := range enum.Options() {
+					fmt.Fprintf(conn, "%d: %s\n", i, option)
+				}
+				fmt.Fprintln(conn, "ok")
+			case "undef":
+				if err := broker.RemoveAttribute(node.ID + "." + packet.Args["name"]); err != nil {
+					fmt.Fprintln(conn, "err", err)
+					continue
+				}
+				for i, attr := range node.Attributes {
+					if attr.Name == packet.Args["name"] {
+						node.Attributes = append(node.Attributes[:i], node.Attributes[i+1:]...)
+						break
+					}
+				}
 				fmt.Fprintln(conn, "ok")
 			default:
 				fmt.Fprintln(conn, "unknown command")
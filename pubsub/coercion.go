@@ -0,0 +1,210 @@
+package pubsub
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Coercion controls how far a definition goes to turn a published value into its own type
+type Coercion int
+
+const (
+	// CoerceLenient accepts common spellings such as "on" or "yes" for booleans and rounds numbers to the nearest integer
+	CoerceLenient Coercion = iota
+	// CoerceStrict only accepts values that convert without losing anything, 3.7 is not an integer and "yes" is not a boolean
+	CoerceStrict
+)
+
+func (c Coercion) String() string {
+	switch c {
+	case CoerceLenient:
+		return "lenient"
+	case CoerceStrict:
+		return "strict"
+	}
+	return "unknown"
+}
+
+var (
+	lenientTrue  = []string{"true", "t", "1", "on", "yes", "y"}
+	lenientFalse = []string{"false", "f", "0", "off", "no", "n"}
+)
+
+func contains(list []string, s string) bool {
+	for _, i := range list {
+		if i == s {
+			return true
+		}
+	}
+	return false
+}
+
+// float32ToFloat64 keeps the shortest decimal of a float32 in lenient mode, so float32(0.1) stays 0.1
+func float32ToFloat64(f float32, c Coercion) float64 {
+	if c == CoerceStrict {
+		return float64(f)
+	}
+	v, _ := strconv.ParseFloat(strconv.FormatFloat(float64(f), 'g', -1, 32), 64)
+	return v
+}
+
+func coerceInt(v interface{}, c Coercion) (int64, error) {
+	rv := reflect.ValueOf(v)
+	switch kindOf(v) {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if rv.Uint() > math.MaxInt64 {
+			return 0, ErrLossyConversion{Value: v, Expected: reflect.Int64}
+		}
+		return int64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if rv.Kind() == reflect.Float32 {
+			f = float32ToFloat64(float32(f), c)
+		}
+		if c != CoerceStrict {
+			f = math.Round(f)
+		}
+		// 2^63 is the first float64 that no longer fits
+		if math.Trunc(f) != f || f < math.MinInt64 || f >= math.MaxInt64 {
+			return 0, ErrLossyConversion{Value: v, Expected: reflect.Int64}
+		}
+		return int64(f), nil
+	case reflect.Bool:
+		if c == CoerceStrict {
+			break
+		}
+		if rv.Bool() {
+			return 1, nil
+		}
+		return 0, nil
+	case reflect.String:
+		s := rv.String()
+		if c != CoerceStrict {
+			s = strings.TrimSpace(s)
+		}
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err == nil || c == CoerceStrict {
+			return i, err
+		}
+		f, ferr := strconv.ParseFloat(s, 64)
+		if ferr != nil {
+			return 0, err
+		}
+		if i, err = coerceInt(f, c); err != nil {
+			return 0, ErrLossyConversion{Value: v, Expected: reflect.Int64}
+		}
+		return i, nil
+	}
+	return 0, ErrInvalidType{Expected: reflect.Int64, Actual: kindOf(v)}
+}
+
+func coerceFloat(v interface{}, c Coercion) (float64, error) {
+	rv := reflect.ValueOf(v)
+	switch kindOf(v) {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		f := float64(rv.Int())
+		// integers past 2^53 do not all have a float64
+		if c == CoerceStrict && (f >= math.MaxInt64 || int64(f) != rv.Int()) {
+			return 0, ErrLossyConversion{Value: v, Expected: reflect.Float64}
+		}
+		return f, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		f := float64(rv.Uint())
+		if c == CoerceStrict && (f >= math.MaxUint64 || uint64(f) != rv.Uint()) {
+			return 0, ErrLossyConversion{Value: v, Expected: reflect.Float64}
+		}
+		return f, nil
+	case reflect.Float32:
+		return float32ToFloat64(float32(rv.Float()), c), nil
+	case reflect.Float64:
+		return rv.Float(), nil
+	case reflect.Bool:
+		if c == CoerceStrict {
+			break
+		}
+		if rv.Bool() {
+			return 1, nil
+		}
+		return 0, nil
+	case reflect.String:
+		s := rv.String()
+		if c != CoerceStrict {
+			s = strings.TrimSpace(s)
+		}
+		return strconv.ParseFloat(s, 64)
+	}
+	return 0, ErrInvalidType{Expected: reflect.Float64, Actual: kindOf(v)}
+}
+
+func coerceBool(v interface{}, c Coercion) (bool, error) {
+	rv := reflect.ValueOf(v)
+	switch kindOf(v) {
+	case reflect.Bool:
+		return rv.Bool(), nil
+	case reflect.String:
+		s := rv.String()
+		if c == CoerceStrict {
+			if s == "true" || s == "false" {
+				return s == "true", nil
+			}
+			break
+		}
+		s = strings.ToLower(strings.TrimSpace(s))
+		if contains(lenientTrue, s) {
+			return true, nil
+		}
+		if contains(lenientFalse, s) {
+			return false, nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		f, _ := coerceFloat(v, CoerceLenient)
+		if c != CoerceStrict {
+			return f != 0, nil
+		}
+		if f == 0 || f == 1 {
+			return f == 1, nil
+		}
+	default:
+		return false, ErrInvalidType{Expected: reflect.Bool, Actual: kindOf(v)}
+	}
+	return false, ErrLossyConversion{Value: v, Expected: reflect.Bool}
+}
+
+func coerceString(v interface{}, c Coercion) (string, error) {
+	rv := reflect.ValueOf(v)
+	switch kindOf(v) {
+	case reflect.String:
+		return rv.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if c != CoerceStrict {
+			return strconv.FormatInt(rv.Int(), 10), nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if c != CoerceStrict {
+			return strconv.FormatUint(rv.Uint(), 10), nil
+		}
+	case reflect.Float32:
+		if c != CoerceStrict {
+			return strconv.FormatFloat(rv.Float(), 'f', -1, 32), nil
+		}
+	case reflect.Float64:
+		if c != CoerceStrict {
+			return strconv.FormatFloat(rv.Float(), 'f', -1, 64), nil
+		}
+	case reflect.Bool:
+		if c != CoerceStrict {
+			return strconv.FormatBool(rv.Bool()), nil
+		}
+	case reflect.Slice:
+		if b, ok := v.([]byte); ok && c != CoerceStrict {
+			return string(b), nil
+		}
+	}
+	return "", ErrInvalidType{Expected: reflect.String, Actual: kindOf(v)}
+}
@@ -0,0 +1,166 @@
+package pubsub
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestCoercion(t *testing.T) {
+	lenientInt, strictInt := IntegerDefinition{}, IntegerDefinition{Coercion: CoerceStrict}
+	lenientDouble, strictDouble := DoubleDefinition{}, DoubleDefinition{Coercion: CoerceStrict}
+	lenientBool, strictBool := BooleanDefinition{}, BooleanDefinition{Coercion: CoerceStrict}
+	lenientString, strictString := StringDefinition{}, StringDefinition{Coercion: CoerceStrict}
+
+	tests := []struct {
+		Def      Definition
+		In       interface{}
+		Expected interface{}
+		Err      error
+	}{
+		// integer
+		{lenientInt, 3, int64(3), nil},
+		{lenientInt, int8(-3), int64(-3), nil},
+		{lenientInt, int16(300), int64(300), nil},
+		{lenientInt, int32(-70000), int64(-70000), nil},
+		{lenientInt, int64(math.MaxInt64), int64(math.MaxInt64), nil},
+		{lenientInt, uint(3), int64(3), nil},
+		{lenientInt, uint8(255), int64(255), nil},
+		{lenientInt, uint16(65535), int64(65535), nil},
+		{lenientInt, uint32(math.MaxUint32), int64(math.MaxUint32), nil},
+		{lenientInt, uint64(math.MaxInt64), int64(math.MaxInt64), nil},
+		{lenientInt, uint64(math.MaxUint64), nil, ErrLossyConversion{}},
+		{lenientInt, float32(2.5), int64(3), nil},
+		{lenientInt, 3.7, int64(4), nil},
+		{lenientInt, -3.7, int64(-4), nil},
+		{lenientInt, 1e19, nil, ErrLossyConversion{}},
+		{lenientInt, math.NaN(), nil, ErrLossyConversion{}},
+		{lenientInt, math.Inf(-1), nil, ErrLossyConversion{}},
+		{lenientInt, true, int64(1), nil},
+		{lenientInt, false, int64(0), nil},
+		{lenientInt, "42", int64(42), nil},
+		{lenientInt, " 42 ", int64(42), nil},
+		{lenientInt, "3.7", int64(4), nil},
+		{lenientInt, "9223372036854775808", nil, ErrLossyConversion{}},
+		{lenientInt, "four", nil, &strconv.NumError{}},
+		{lenientInt, nil, nil, ErrInvalidType{}},
+		{lenientInt, []int{1}, nil, ErrInvalidType{}},
+		{strictInt, 3, int64(3), nil},
+		{strictInt, uint64(math.MaxUint64), nil, ErrLossyConversion{}},
+		{strictInt, 3.0, int64(3), nil},
+		{strictInt, float32(3), int64(3), nil},
+		{strictInt, 3.7, nil, ErrLossyConversion{}},
+		{strictInt, float64(math.MaxInt64), nil, ErrLossyConversion{}},
+		{strictInt, true, nil, ErrInvalidType{}},
+		{strictInt, "42", int64(42), nil},
+		{strictInt, " 42", nil, &strconv.NumError{}},
+		{strictInt, "3.7", nil, &strconv.NumError{}},
+
+		// double
+		{lenientDouble, 3, 3.0, nil},
+		{lenientDouble, int8(-3), -3.0, nil},
+		{lenientDouble, uint16(7), 7.0, nil},
+		{lenientDouble, int64(1<<53 + 1), float64(1 << 53), nil},
+		{lenientDouble, float32(0.1), 0.1, nil},
+		{lenientDouble, 0.1, 0.1, nil},
+		{lenientDouble, true, 1.0, nil},
+		{lenientDouble, " 2.5 ", 2.5, nil},
+		{lenientDouble, "warm", nil, &strconv.NumError{}},
+		{lenientDouble, nil, nil, ErrInvalidType{}},
+		{strictDouble, int64(1 << 53), float64(1 << 53), nil},
+		{strictDouble, int64(1<<53 + 1), nil, ErrLossyConversion{}},
+		{strictDouble, uint64(1<<53 + 1), nil, ErrLossyConversion{}},
+		{strictDouble, int64(math.MaxInt64), nil, ErrLossyConversion{}},
+		{strictDouble, float32(0.5), 0.5, nil},
+		{strictDouble, float32(0.1), float64(float32(0.1)), nil},
+		{strictDouble, true, nil, ErrInvalidType{}},
+		{strictDouble, "2.5", 2.5, nil},
+		{strictDouble, " 2.5", nil, &strconv.NumError{}},
+
+		// boolean
+		{lenientBool, true, true, nil},
+		{lenientBool, "true", true, nil},
+		{lenientBool, "ON", true, nil},
+		{lenientBool, "yes", true, nil},
+		{lenientBool, "y", true, nil},
+		{lenientBool, "1", true, nil},
+		{lenientBool, " off ", false, nil},
+		{lenientBool, "No", false, nil},
+		{lenientBool, "0", false, nil},
+		{lenientBool, "maybe", nil, ErrLossyConversion{}},
+		{lenientBool, "", nil, ErrLossyConversion{}},
+		{lenientBool, 2, true, nil},
+		{lenientBool, -1, true, nil},
+		{lenientBool, 0.0, false, nil},
+		{lenientBool, uint8(1), true, nil},
+		{lenientBool, nil, nil, ErrInvalidType{}},
+		{strictBool, false, false, nil},
+		{strictBool, "true", true, nil},
+		{strictBool, "false", false, nil},
+		{strictBool, "yes", nil, ErrLossyConversion{}},
+		{strictBool, "True", nil, ErrLossyConversion{}},
+		{strictBool, 1, true, nil},
+		{strictBool, 0.0, false, nil},
+		{strictBool, 2, nil, ErrLossyConversion{}},
+		{strictBool, 0.5, nil, ErrLossyConversion{}},
+
+		// string
+		{lenientString, "hi", "hi", nil},
+		{lenientString, 42, "42", nil},
+		{lenientString, uint64(math.MaxUint64), "18446744073709551615", nil},
+		{lenientString, float32(0.1), "0.1", nil},
+		{lenientString, 2.50, "2.5", nil},
+		{lenientString, true, "true", nil},
+		{lenientString, []byte("raw"), "raw", nil},
+		{lenientString, nil, nil, ErrInvalidType{}},
+		{lenientString, []int{1}, nil, ErrInvalidType{}},
+		{strictString, "hi", "hi", nil},
+		{strictString, 42, nil, ErrInvalidType{}},
+		{strictString, true, nil, ErrInvalidType{}},
+		{strictString, []byte("raw"), nil, ErrInvalidType{}},
+	}
+	for i, test := range tests {
+		v, err := test.Def.ValidateAndTransform(test.In)
+		if test.Err != nil {
+			if err == nil || fmt.Sprintf("%T", err) != fmt.Sprintf("%T", test.Err) {
+				t.Errorf("%d %T input:%#v expected %T got value:%#v err:%v", i, test.Def, test.In, test.Err, v, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d %T input:%#v unexpected error %s", i, test.Def, test.In, err)
+			continue
+		}
+		if v != test.Expected {
+			t.Errorf("%d %T input:%#v expected %#v got %#v", i, test.Def, test.In, test.Expected, v)
+		}
+	}
+}
+
+func TestInspect(t *testing.T) {
+	tests := []struct {
+		Def      Definition
+		In       interface{}
+		Expected string
+	}{
+		{IntegerDefinition{}, int64(42), "42"},
+		{IntegerDefinition{}, int64(-7), "-7"},
+		{IntegerDefinition{}, int64(math.MaxInt64), "9223372036854775807"},
+		{IntegerDefinition{Unit: Watt}, int64(1500), "1500 W"},
+		{DoubleDefinition{}, 0.1, "0.1"},
+		{DoubleDefinition{}, 20.0, "20"},
+		{DoubleDefinition{}, 1e21, "1000000000000000000000"},
+		{DoubleDefinition{Constraints: Constraints{Precision: 2}}, 20.0, "20.00"},
+		{BooleanDefinition{}, true, "true"},
+		{BooleanDefinition{}, false, "false"},
+		{StringDefinition{}, "hi", "hi"},
+		{EnumDefinition{Values: []string{"off"}}, "off", "off"},
+		{IntegerDefinition{}, "not an int64", ""},
+	}
+	for i, test := range tests {
+		if s := test.Def.Inspect(test.In); s != test.Expected {
+			t.Errorf("%d %T input:%#v expected '%s' got '%s'", i, test.Def, test.In, test.Expected, s)
+		}
+	}
+}
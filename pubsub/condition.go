@@ -0,0 +1,182 @@
+package pubsub
+
+import (
+	"reflect"
+	"sort"
+)
+
+// Edge selects the transitions of a boolean attribute a Condition fires on
+type Edge int
+
+const (
+	NoEdge Edge = iota
+	// RisingEdge fires when the value goes from false to true
+	RisingEdge
+	// FallingEdge fires when the value goes from true to false
+	FallingEdge
+	// AnyEdge fires on both
+	AnyEdge
+)
+
+// Threshold is a level a numeric value crosses, once crossed it has to move back past the level
+// by Hysteresis before it can fire again
+type Threshold struct {
+	Level      float64
+	Hysteresis float64
+}
+
+// Condition restricts the values a subscription is called with, every field that is set must hold.
+// It is evaluated against the previous record of the attribute, which Context.Previous hands to Fn.
+// The zero value always holds
+type Condition struct {
+	// Predicate must return true for the new value, previous is nil for the first value.
+	// It runs while the attribute is being fanned out so it must not publish to it
+	Predicate func(previous *Value, v Value) bool
+	// Rising fires when a numeric value rises to or above the level
+	Rising *Threshold
+	// Falling fires when a numeric value falls to or below the level, set along with Rising either
+	// crossing fires
+	Falling *Threshold
+	// Changed requires the value to differ from the previous one
+	Changed bool
+	// ChangedFrom and ChangedTo require the previous and the new value to be these, they are
+	// compared after the definition of the attribute transformed them so "on" matches true
+	ChangedFrom interface{}
+	ChangedTo   interface{}
+	Edge        Edge
+}
+
+// conditionState keeps the hysteresis of one attribute for one subscription
+type conditionState struct {
+	risingArmed  bool
+	fallingArmed bool
+}
+
+func (c Condition) isZero() bool {
+	return c.Predicate == nil && c.Rising == nil && c.Falling == nil && !c.Changed &&
+		c.ChangedFrom == nil && c.ChangedTo == nil && c.Edge == NoEdge
+}
+
+// previous returns the newest record older than recordId
+func (ctx *attributeCtx) previous(recordId int) (ValueRecord, bool) {
+	ctx.lock.RLock()
+	defer ctx.lock.RUnlock()
+	i := sort.Search(len(ctx.Records), func(i int) bool {
+		return ctx.Records[i].RecordId >= recordId
+	})
+	if i == 0 {
+		return ValueRecord{}, false
+	}
+	return copyRecord(ctx.Records[i-1]), true
+}
+
+func sameValue(d Definition, expected interface{}, actual interface{}) bool {
+	if v, err := d.ValidateAndTransform(expected); err == nil {
+		expected = v
+	}
+	return reflect.DeepEqual(expected, actual)
+}
+
+// holds evaluates the condition of sub for rec and advances its hysteresis, calls for the records
+// of one attribute must come in order
+func (sub *subscriptionCtx) holds(recCtx *attributeCtx, rec *ValueRecord) bool {
+	c := sub.When
+	if c.isZero() {
+		return true
+	}
+	v, err := sub.view(rec.Value)
+	if err != nil {
+		// call reports the conversion error
+		return true
+	}
+	var prev *Value
+	if p, ok := recCtx.previous(rec.RecordId); ok {
+		if pv, err := sub.view(p.Value); err == nil {
+			prev = &pv
+		}
+	}
+
+	holds := true
+	if c.Rising != nil || c.Falling != nil {
+		holds = sub.crossed(recCtx, prev, v) && holds
+	}
+	if c.Changed && (prev == nil || reflect.DeepEqual(prev.Value, v.Value)) {
+		holds = false
+	}
+	if c.ChangedFrom != nil && (prev == nil || !sameValue(recCtx.Attribute.Definition, c.ChangedFrom, prev.Value)) {
+		holds = false
+	}
+	if c.ChangedTo != nil && !sameValue(recCtx.Attribute.Definition, c.ChangedTo, v.Value) {
+		holds = false
+	}
+	if c.ChangedTo != nil && c.ChangedFrom == nil && prev != nil && reflect.DeepEqual(prev.Value, v.Value) {
+		holds = false
+	}
+	if c.Edge != NoEdge {
+		now, nowOk := v.Value.(bool)
+		var was, wasOk bool
+		if prev != nil {
+			was, wasOk = prev.Value.(bool)
+		}
+		rising := nowOk && wasOk && !was && now
+		falling := nowOk && wasOk && was && !now
+		switch c.Edge {
+		case RisingEdge:
+			holds = holds && rising
+		case FallingEdge:
+			holds = holds && falling
+		case AnyEdge:
+			holds = holds && (rising || falling)
+		}
+	}
+	if holds && c.Predicate != nil {
+		holds = c.Predicate(prev, v)
+	}
+	return holds
+}
+
+// crossed evaluates the thresholds of the condition, the hysteresis is kept even when other parts do not hold
+func (sub *subscriptionCtx) crossed(recCtx *attributeCtx, prev *Value, v Value) bool {
+	c := sub.When
+	if !isNumeric(recCtx.Attribute.Definition) {
+		return false
+	}
+	f := toFloat(v.Value)
+
+	sub.lock.Lock()
+	defer sub.lock.Unlock()
+	if sub.conditions == nil {
+		sub.conditions = make(map[*attributeCtx]*conditionState)
+	}
+	state, ok := sub.conditions[recCtx]
+	if !ok {
+		// without a previous value the first value to reach a level counts as crossing it
+		state = &conditionState{risingArmed: true, fallingArmed: true}
+		if prev != nil && c.Rising != nil && toFloat(prev.Value) >= c.Rising.Level {
+			state.risingArmed = false
+		}
+		if prev != nil && c.Falling != nil && toFloat(prev.Value) <= c.Falling.Level {
+			state.fallingArmed = false
+		}
+		sub.conditions[recCtx] = state
+	}
+
+	fired := false
+	if c.Rising != nil {
+		if state.risingArmed && f >= c.Rising.Level {
+			state.risingArmed = false
+			fired = true
+		} else if !state.risingArmed && f <= c.Rising.Level-c.Rising.Hysteresis && f < c.Rising.Level {
+			state.risingArmed = true
+		}
+	}
+	if c.Falling != nil {
+		if state.fallingArmed && f <= c.Falling.Level {
+			state.fallingArmed = false
+			fired = true
+		} else if !state.fallingArmed && f >= c.Falling.Level+c.Falling.Hysteresis && f > c.Falling.Level {
+			state.fallingArmed = true
+		}
+	}
+	return fired
+}
@@ -0,0 +1,179 @@
+package pubsub
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSubscriptionCondition(t *testing.T) {
+	tests := []struct {
+		Name       string
+		Definition Definition
+		When       Condition
+		Publish    []interface{}
+		Expected   []string
+	}{
+		{
+			Name:       "zero",
+			Definition: IntegerDefinition{},
+			Publish:    []interface{}{1, 1, 2},
+			Expected:   []string{"0", "1", "1", "2"},
+		},
+		{
+			Name:       "predicate",
+			Definition: IntegerDefinition{},
+			When: Condition{Predicate: func(previous *Value, v Value) bool {
+				return previous != nil && v.Value.(int64) > previous.Value.(int64)
+			}},
+			Publish:  []interface{}{5, 3, 4, 4, 9},
+			Expected: []string{"5", "4", "9"},
+		},
+		{
+			Name:       "rising with hysteresis",
+			Definition: DoubleDefinition{},
+			When:       Condition{Rising: &Threshold{Level: 30, Hysteresis: 2}},
+			Publish:    []interface{}{29, 30, 31, 29, 30, 27, 30.5},
+			Expected:   []string{"30", "30.5"},
+		},
+		{
+			Name:       "falling",
+			Definition: DoubleDefinition{},
+			When:       Condition{Falling: &Threshold{Level: 10}},
+			Publish:    []interface{}{20, 10, 5, 11, 9},
+			// the default is the first value to fall below the level
+			Expected: []string{"0", "10", "9"},
+		},
+		{
+			Name:       "rising and falling",
+			Definition: DoubleDefinition{},
+			When:       Condition{Rising: &Threshold{Level: 30}, Falling: &Threshold{Level: 10}},
+			Publish:    []interface{}{35, 20, 5, 15, 31},
+			Expected:   []string{"0", "35", "5", "31"},
+		},
+		{
+			Name:       "changed",
+			Definition: StringDefinition{},
+			When:       Condition{Changed: true},
+			Publish:    []interface{}{"a", "a", "b", "b", "a"},
+			Expected:   []string{"a", "b", "a"},
+		},
+		{
+			Name:       "changed to",
+			Definition: BooleanDefinition{},
+			When:       Condition{ChangedTo: "on"},
+			Publish:    []interface{}{true, true, false, "on"},
+			Expected:   []string{"true", "true"},
+		},
+		{
+			Name:       "changed from to",
+			Definition: StringDefinition{},
+			When:       Condition{ChangedFrom: "idle", ChangedTo: "running"},
+			Publish:    []interface{}{"running", "idle", "stopped", "idle", "running"},
+			Expected:   []string{"running"},
+		},
+		{
+			Name:       "rising edge",
+			Definition: BooleanDefinition{},
+			When:       Condition{Edge: RisingEdge},
+			Publish:    []interface{}{true, true, false, true},
+			Expected:   []string{"true", "true"},
+		},
+		{
+			Name:       "falling edge",
+			Definition: BooleanDefinition{},
+			When:       Condition{Edge: FallingEdge},
+			Publish:    []interface{}{true, false, false, true, false},
+			Expected:   []string{"false", "false"},
+		},
+		{
+			Name:       "any edge",
+			Definition: BooleanDefinition{},
+			When:       Condition{Edge: AnyEdge},
+			Publish:    []interface{}{true, true, false},
+			Expected:   []string{"true", "false"},
+		},
+	}
+	for _, test := range tests {
+		for _, async := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s async=%t", test.Name, async), func(t *testing.T) {
+				broker := &Broker{}
+				sensor := BasicNode{ID: "sensor", Attributes: []Attribute{{Name: "v", Definition: test.Definition}}}
+				if err := broker.Register(sensor); err != nil {
+					t.Fatal(err)
+				}
+				values := make(chan string, 16)
+				watcher := BasicNode{ID: "watcher", Subscriptions: []Subscription{{
+					Name:     "w",
+					Filter:   "sensor.v",
+					Snapshot: true,
+					When:     test.When,
+					Delivery: Delivery{Async: async},
+					Fn: func(ctx Context, v Value) {
+						values <- v.Inspect()
+					},
+				}}}
+				if err := broker.Register(watcher); err != nil {
+					t.Fatal(err)
+				}
+				for _, v := range test.Publish {
+					if err := broker.Publish(sensor, "sensor.v", v); err != nil {
+						t.Fatal(err)
+					}
+				}
+				var got []string
+				timeout := time.After(time.Second)
+			collect:
+				for len(got) < len(test.Expected) {
+					select {
+					case v := <-values:
+						got = append(got, v)
+					case <-timeout:
+						break collect
+					}
+				}
+				// anything more is a value the condition should have held back
+				time.Sleep(10 * time.Millisecond)
+				for len(values) > 0 {
+					got = append(got, <-values)
+				}
+				if fmt.Sprint(got) != fmt.Sprint(test.Expected) {
+					t.Errorf("expected %v got %v", test.Expected, got)
+				}
+			})
+		}
+	}
+}
+
+func TestContextPrevious(t *testing.T) {
+	broker := &Broker{}
+	sensor := BasicNode{ID: "sensor", Attributes: []Attribute{
+		{Name: "temp", Definition: DoubleDefinition{Unit: Celsius}},
+	}}
+	if err := broker.Register(sensor); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	watcher := BasicNode{ID: "watcher", Subscriptions: []Subscription{{
+		Name:   "w",
+		Filter: "sensor.temp",
+		Unit:   Kelvin,
+		When:   Condition{Changed: true},
+		Fn: func(ctx Context, v Value) {
+			previous, ok := ctx.Previous()
+			got = append(got, fmt.Sprintf("%t %s -> %s", ok, previous.Inspect(), v.Inspect()))
+		},
+	}}}
+	if err := broker.Register(watcher); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []float64{0, 0, 100} {
+		if err := broker.Publish(sensor, "sensor.temp", v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := fmt.Sprint([]string{"true 273.15 K -> 373.15 K"})
+	if fmt.Sprint(got) != expected {
+		t.Errorf("expected %s got %v", expected, got)
+	}
+}
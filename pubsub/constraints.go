@@ -0,0 +1,182 @@
+package pubsub
+
+import "math"
+
+type RangePolicy int
+
+const (
+	// RangeReject fails values outside of the constraints with ErrOutOfRange or ErrOutOfStep
+	RangeReject RangePolicy = iota
+	// RangeClamp moves values outside of the constraints to the nearest allowed value
+	RangeClamp
+)
+
+func (p RangePolicy) String() string {
+	switch p {
+	case RangeReject:
+		return "reject"
+	case RangeClamp:
+		return "clamp"
+	}
+	return "unknown"
+}
+
+// Constraints restrict the values of a numeric definition, the zero value allows any number.
+// Min and Max are pointers since zero is a meaningful bound, see Bound
+type Constraints struct {
+	Min *float64
+	Max *float64
+	// Step makes values multiples of Step counted from Min, or from zero without a Min
+	Step float64
+	// Precision is the number of decimals a DoubleDefinition rounds to and shows, zero leaves values as they are
+	Precision int
+	Policy    RangePolicy
+}
+
+// ConstrainedDefinition is implemented by the numeric definitions so clients can render their constraints
+type ConstrainedDefinition interface {
+	NumericConstraints() Constraints
+}
+
+// Bound is a helper to set Constraints.Min and Constraints.Max inline
+func Bound(v float64) *float64 {
+	return &v
+}
+
+func (c Constraints) isZero() bool {
+	return c.Min == nil && c.Max == nil && c.Step <= 0 && c.Precision <= 0
+}
+
+// defaultValue is zero clamped into Min, Max and Step
+func (c Constraints) defaultValue() float64 {
+	c.Policy = RangeClamp
+	v, err := c.apply(0)
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+func (c Constraints) apply(v float64) (float64, error) {
+	// NaN passes every comparison and an infinity cannot be clamped or stepped
+	if math.IsNaN(v) || math.IsInf(v, 0) && !c.isZero() {
+		return 0, ErrOutOfRange{Value: v, Min: c.Min, Max: c.Max}
+	}
+	if c.Min != nil && v < *c.Min {
+		if c.Policy != RangeClamp {
+			return 0, ErrOutOfRange{Value: v, Min: c.Min, Max: c.Max}
+		}
+		v = *c.Min
+	}
+	if c.Max != nil && v > *c.Max {
+		if c.Policy != RangeClamp {
+			return 0, ErrOutOfRange{Value: v, Min: c.Min, Max: c.Max}
+		}
+		v = *c.Max
+	}
+	if c.Step > 0 {
+		base := 0.0
+		if c.Min != nil {
+			base = *c.Min
+		}
+		steps := (v - base) / c.Step
+		// allow for the error of binary fractions such as 0.1
+		if nearest := math.Round(steps); math.Abs(steps-nearest) > 1e-9 {
+			if c.Policy != RangeClamp {
+				return 0, ErrOutOfStep{Value: v, Step: c.Step}
+			}
+			steps = nearest
+			// snapping up can leave the range so step back inside it
+			if c.Max != nil && base+steps*c.Step > *c.Max {
+				steps--
+			}
+			v = base + steps*c.Step
+		}
+	}
+	if c.Precision > 0 {
+		scale := math.Pow(10, float64(c.Precision))
+		v = math.Round(v*scale) / scale
+	}
+	return v, nil
+}
+
+// maxExactInt is the largest magnitude up to which every integer is exact in a float64
+const maxExactInt = 1 << 53
+
+// saturate converts a whole float64 to int64, bounds beyond the int64 range are moved onto it
+func saturate(f float64) int64 {
+	if f >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	if f <= math.MinInt64 {
+		return math.MinInt64
+	}
+	return int64(f)
+}
+
+// applyInt is apply in int64 arithmetic so integers beyond 2^53 are neither rounded nor wrapped around
+func (c Constraints) applyInt(v int64) (int64, error) {
+	min, max := int64(math.MinInt64), int64(math.MaxInt64)
+	if c.Min != nil {
+		min = saturate(math.Ceil(*c.Min))
+	}
+	if c.Max != nil {
+		max = saturate(math.Floor(*c.Max))
+	}
+	if v < min {
+		if c.Policy != RangeClamp {
+			return 0, ErrOutOfRange{Value: float64(v), Min: c.Min, Max: c.Max}
+		}
+		v = min
+	}
+	if v > max {
+		if c.Policy != RangeClamp {
+			return 0, ErrOutOfRange{Value: float64(v), Min: c.Min, Max: c.Max}
+		}
+		v = max
+	}
+	if c.Step <= 0 {
+		return v, nil
+	}
+
+	step, base := saturate(c.Step), int64(0)
+	if c.Min != nil {
+		base = min
+	}
+	if float64(step) != c.Step || c.Min != nil && float64(base) != *c.Min {
+		// a fractional step or base can only be counted in floating point
+		if v > maxExactInt || v < -maxExactInt {
+			return 0, ErrOutOfStep{Value: float64(v), Step: c.Step}
+		}
+		f, err := c.apply(float64(v))
+		return int64(math.Round(f)), err
+	}
+
+	// the distance to base can exceed int64 but not uint64
+	var rem int64
+	if v >= base {
+		rem = int64((uint64(v) - uint64(base)) % uint64(step))
+	} else {
+		rem = -int64((uint64(base) - uint64(v)) % uint64(step))
+	}
+	if rem == 0 {
+		return v, nil
+	}
+	if c.Policy != RangeClamp {
+		return 0, ErrOutOfStep{Value: float64(v), Step: c.Step}
+	}
+	// snap to the nearest multiple, halfway snaps away from base like apply does, unless that leaves
+	// the range or int64
+	if rem > 0 {
+		lower := v - rem
+		if rem >= step-rem && uint64(max)-uint64(lower) >= uint64(step) {
+			return lower + step, nil
+		}
+		return lower, nil
+	}
+	upper := v - rem
+	if -rem < step+rem || uint64(upper)-uint64(min) < uint64(step) {
+		return upper, nil
+	}
+	return upper - step, nil
+}
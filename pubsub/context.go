@@ -1,29 +1,94 @@
 package pubsub
 
-import "time"
+import (
+	"context"
+	"sync"
+	"time"
+)
 
 type Context interface {
+	// Context is the context of the publish that triggered the subscription, bounded by Subscription.Timeout
+	Context() context.Context
 	Publish(attr string, value interface{}) error
 	Value(attr string, at time.Time) (ValueRecord, error)
+	// Previous is the value the attribute had before the one being delivered, in the unit of the subscription
+	Previous() (Value, bool)
+	// Call invokes a function, what it publishes is caused by the value being delivered
+	Call(fn string, args map[string]interface{}) (interface{}, error)
 	Error(error)
 }
 type executionContext struct {
+	ctx       context.Context
 	broker    *Broker
 	publisher string
+	cause     *ValueRecord
+	sub       *subscriptionCtx
+	recCtx    *attributeCtx
+	lock      sync.Mutex
 	errors    []error
 }
 
+func (ctx *executionContext) Context() context.Context {
+	return ctx.ctx
+}
+
 func (ctx *executionContext) Value(attr string, at time.Time) (ValueRecord, error) {
 	return ctx.broker.Value(attr, at)
 }
 
+func (ctx *executionContext) Previous() (Value, bool) {
+	if ctx.recCtx == nil || ctx.cause == nil {
+		return Value{}, false
+	}
+	rec, ok := ctx.recCtx.previous(ctx.cause.RecordId)
+	if !ok {
+		return Value{}, false
+	}
+	v, err := ctx.sub.view(rec.Value)
+	return v, err == nil
+}
+
 func (ctx *executionContext) Error(err error) {
 	if err == nil {
 		return
 	}
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
 	ctx.errors = append(ctx.errors, err)
 }
 
+func (ctx *executionContext) Errors() []error {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+	return append([]error(nil), ctx.errors...)
+}
+
 func (ctx *executionContext) Publish(attr string, value interface{}) error {
-	return ctx.broker.publish(ctx.publisher, attr, value)
+	return ctx.broker.publish(ctx.ctx, ctx.publisher, attr, value, ctx.cause, nil)
+}
+
+func (ctx *executionContext) Call(fn string, args map[string]interface{}) (interface{}, error) {
+	return ctx.broker.invoke(ctx.ctx, ctx.publisher, fn, args, ctx.cause)
+}
+
+// detachedContext keeps the values of its parent but is never done, it carries the context of a
+// publish over to an asynchronous delivery that outlives it
+type detachedContext struct {
+	parent context.Context
+}
+
+func (c detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (c detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (c detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
 }
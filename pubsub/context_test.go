@@ -0,0 +1,111 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type contextKey string
+
+func TestPublishContextTimeout(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	n1 := BasicNode{
+		ID: "n1",
+		Attributes: []Attribute{
+			{Name: "ctx", Definition: StringDefinition{AcceptContextFn: func(ctx context.Context, v string) error {
+				select {
+				case <-block:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}}},
+			{Name: "legacy", Definition: StringDefinition{AcceptFn: func(v string) error {
+				<-block
+				return nil
+			}}},
+		},
+	}
+	other := BasicNode{ID: "other"}
+	broker := &Broker{}
+	if err := broker.Register(n1); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, attr := range []string{"n1.ctx", "n1.legacy"} {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		err := broker.PublishContext(ctx, other, attr, "x")
+		cancel()
+		var timeout ErrPublishTimeout
+		if !errors.As(err, &timeout) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s expected ErrPublishTimeout got %v", attr, err)
+		}
+		if rec, _ := broker.Value(attr, time.Now().Add(time.Second)); rec.Inspect() != "" {
+			t.Errorf("%s expected the timed out value not to be recorded got '%s'", attr, rec.Inspect())
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := broker.PublishContext(ctx, n1, "n1.ctx", "x"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a cancelled context to fail the publish got %v", err)
+	}
+}
+
+func TestSubscriptionContext(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	n1 := BasicNode{
+		ID: "n1",
+		Attributes: []Attribute{
+			{Name: "in", Definition: StringDefinition{}},
+			{Name: "slow", Definition: StringDefinition{AcceptFn: func(v string) error {
+				<-block
+				return nil
+			}}},
+		},
+	}
+	broker := &Broker{}
+	if err := broker.Register(n1); err != nil {
+		t.Fatal(err)
+	}
+
+	var seen interface{}
+	err := broker.Subscribe(BasicNode{ID: "sub"}, Subscription{
+		Name:    "forward",
+		Filter:  "n1.in",
+		Timeout: 20 * time.Millisecond,
+		Fn: func(ctx Context, v Value) {
+			seen = ctx.Context().Value(contextKey("request"))
+			ctx.Error(ctx.Publish("n1.slow", v.Value))
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.WithValue(context.Background(), contextKey("request"), "r1")
+	if err := broker.PublishContext(ctx, n1, "n1.in", "x"); err != nil {
+		t.Fatal(err)
+	}
+	if seen != "r1" {
+		t.Errorf("expected the subscription to see the publisher's context got %v", seen)
+	}
+
+	rec, err := broker.Value("n1.in", time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var publishTimeout ErrPublishTimeout
+	var subTimeout ErrSubscriptionTimeout
+	errs := rec.SubscriptionResponses[0].Err
+	if len(errs) != 2 || !errors.As(errs[0], &publishTimeout) || !errors.As(errs[1], &subTimeout) {
+		t.Fatalf("expected a publish and a subscription timeout got %v", errs)
+	}
+	if publishTimeout.Attribute != "n1.slow" || subTimeout.Subscription != "sub@forward" {
+		t.Errorf("unexpected errors %v", errs)
+	}
+}
@@ -0,0 +1,600 @@
+package pubsub
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestEnumDefinition(t *testing.T) {
+	def := EnumDefinition{Values: []string{"off", "heat", "cool", "auto"}, Default: "auto"}
+
+	tests := []struct {
+		In       interface{}
+		Expected string
+		Valid    bool
+	}{
+		{"heat", "heat", true},
+		{"off", "off", true},
+		{0, "off", true},
+		{int8(1), "heat", true},
+		{int64(3), "auto", true},
+		{2.0, "cool", true},
+		{"2", "cool", true},
+		{"HEAT", "", false},
+		{"dry", "", false},
+		{4, "", false},
+		{-1, "", false},
+		{1.5, "", false},
+		{"7", "", false},
+		{true, "", false},
+	}
+	for _, test := range tests {
+		v, err := def.ValidateAndTransform(test.In)
+		if !test.Valid {
+			var enumErr ErrInvalidEnumValue
+			var typeErr ErrInvalidType
+			if !errors.As(err, &enumErr) && !errors.As(err, &typeErr) {
+				t.Errorf("input:%#v expected a typed error got value:%v err:%v", test.In, v, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("input:%#v unexpected error %s", test.In, err)
+			continue
+		}
+		if def.Inspect(v) != test.Expected {
+			t.Errorf("input:%#v expected '%s' got '%s'", test.In, test.Expected, def.Inspect(v))
+		}
+	}
+
+	if def.DefaultValue() != "auto" {
+		t.Errorf("expected default 'auto' got %v", def.DefaultValue())
+	}
+	if (EnumDefinition{Values: []string{"a", "b"}}).DefaultValue() != "a" {
+		t.Error("expected the first value to be the default")
+	}
+
+	broker := &Broker{}
+	n1 := BasicNode{ID: "n1", Attributes: []Attribute{{Name: "mode", Definition: def}}}
+	if err := broker.Register(n1); err != nil {
+		t.Fatal(err)
+	}
+	d, err := broker.Definition("n1.mode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options := d.(EnumDefinition).Options(); len(options) != 4 || options[2] != "cool" {
+		t.Errorf("unexpected options %v", options)
+	}
+
+	bad := BasicNode{ID: "n2", Attributes: []Attribute{{Name: "mode", Definition: EnumDefinition{Values: []string{"a"}, Default: "b"}}}}
+	if err := broker.Register(bad); !errors.As(err, &ErrInvalidEnumValue{}) {
+		t.Errorf("expected a default outside of the values to fail registration got %v", err)
+	}
+}
+
+func TestNumericConstraints(t *testing.T) {
+	thermostat := Constraints{Min: Bound(10), Max: Bound(30), Step: 0.5, Precision: 1}
+	clamped := thermostat
+	clamped.Policy = RangeClamp
+
+	tests := []struct {
+		Def      Definition
+		In       interface{}
+		Expected interface{}
+		Err      error
+	}{
+		{DoubleDefinition{Constraints: thermostat}, 21.5, 21.5, nil},
+		{DoubleDefinition{Constraints: thermostat}, 10, 10.0, nil},
+		{DoubleDefinition{Constraints: thermostat}, "30", 30.0, nil},
+		{DoubleDefinition{Constraints: thermostat}, 9.5, nil, ErrOutOfRange{}},
+		{DoubleDefinition{Constraints: thermostat}, 30.5, nil, ErrOutOfRange{}},
+		{DoubleDefinition{Constraints: thermostat}, 21.3, nil, ErrOutOfStep{}},
+		{DoubleDefinition{Constraints: clamped}, 9.5, 10.0, nil},
+		{DoubleDefinition{Constraints: clamped}, 100, 30.0, nil},
+		{DoubleDefinition{Constraints: clamped}, 21.3, 21.5, nil},
+		{DoubleDefinition{Constraints: clamped}, 21.2, 21.0, nil},
+		{DoubleDefinition{Constraints: Constraints{Precision: 2}}, 3.14159, 3.14, nil},
+		{DoubleDefinition{Constraints: Constraints{Step: 0.1}}, 0.3, 0.3, nil},
+		{IntegerDefinition{Constraints: Constraints{Min: Bound(0), Max: Bound(100)}}, 50, int64(50), nil},
+		{IntegerDefinition{Constraints: Constraints{Min: Bound(0), Max: Bound(100)}}, -1, nil, ErrOutOfRange{}},
+		{IntegerDefinition{Constraints: Constraints{Min: Bound(0), Max: Bound(100), Policy: RangeClamp}}, 101, int64(100), nil},
+		{IntegerDefinition{Constraints: Constraints{Min: Bound(0), Step: 5}}, 10, int64(10), nil},
+		{IntegerDefinition{Constraints: Constraints{Min: Bound(0), Step: 5}}, 12, nil, ErrOutOfStep{}},
+		{IntegerDefinition{Constraints: Constraints{Min: Bound(0), Step: 5, Policy: RangeClamp}}, 13, int64(15), nil},
+		{IntegerDefinition{Constraints: Constraints{Min: Bound(0), Max: Bound(12), Step: 5, Policy: RangeClamp}}, 13, int64(10), nil},
+		{DoubleDefinition{Constraints: Constraints{Min: Bound(0), Max: Bound(10)}}, "NaN", nil, ErrOutOfRange{}},
+		{DoubleDefinition{}, math.NaN(), nil, ErrOutOfRange{}},
+		{DoubleDefinition{Constraints: clamped}, math.Inf(1), nil, ErrOutOfRange{}},
+		{DoubleDefinition{}, math.Inf(-1), math.Inf(-1), nil},
+		{IntegerDefinition{Constraints: Constraints{Min: Bound(0)}}, int64(math.MaxInt64), int64(math.MaxInt64), nil},
+		{IntegerDefinition{Constraints: Constraints{Min: Bound(0)}}, int64(9007199254740993), int64(9007199254740993), nil},
+		{IntegerDefinition{Constraints: Constraints{Min: Bound(0), Step: 2}}, int64(9007199254740993), nil, ErrOutOfStep{}},
+		{IntegerDefinition{Constraints: Constraints{Step: 2, Policy: RangeClamp}}, int64(math.MaxInt64), int64(math.MaxInt64 - 1), nil},
+		{IntegerDefinition{Constraints: Constraints{Step: 2, Policy: RangeClamp}}, -3, int64(-4), nil},
+		{IntegerDefinition{Constraints: Constraints{Min: Bound(0.5), Step: 1}}, 3, nil, ErrOutOfStep{}},
+	}
+	for i, test := range tests {
+		v, err := test.Def.ValidateAndTransform(test.In)
+		if test.Err != nil {
+			if err == nil || fmt.Sprintf("%T", err) != fmt.Sprintf("%T", test.Err) {
+				t.Errorf("%d input:%#v expected %T got value:%v err:%v", i, test.In, test.Err, v, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d input:%#v unexpected error %s", i, test.In, err)
+			continue
+		}
+		if v != test.Expected {
+			t.Errorf("%d input:%#v expected %#v got %#v", i, test.In, test.Expected, v)
+		}
+	}
+
+	if v := (DoubleDefinition{Constraints: thermostat}).DefaultValue(); v != 10.0 {
+		t.Errorf("expected the default to be moved inside the range got %v", v)
+	}
+	if v := (IntegerDefinition{Constraints: Constraints{Max: Bound(-5)}}).DefaultValue(); v != int64(-5) {
+		t.Errorf("expected the default to be moved inside the range got %v", v)
+	}
+	// zero is not a step of these ranges so the default snaps to the nearest one
+	stepped := BasicNode{ID: "n", Attributes: []Attribute{
+		{Name: "i", Definition: IntegerDefinition{Constraints: Constraints{Min: Bound(-5), Max: Bound(5), Step: 2}}},
+		{Name: "d", Definition: DoubleDefinition{Constraints: Constraints{Min: Bound(-1), Max: Bound(1), Step: 0.75}}},
+	}}
+	broker := &Broker{}
+	if err := broker.Register(stepped); err != nil {
+		t.Fatal(err)
+	}
+	for attr, expected := range map[string]string{"n.i": "1", "n.d": "-0.25"} {
+		if rec, _ := broker.Value(attr, time.Now()); rec.Inspect() != expected {
+			t.Errorf("%s expected the default %s got %s", attr, expected, rec.Inspect())
+		}
+	}
+	if s := (DoubleDefinition{Constraints: thermostat}).Inspect(21.5); s != "21.5" {
+		t.Errorf("expected the precision to be used by inspect got '%s'", s)
+	}
+
+	var def Definition = IntegerDefinition{Constraints: Constraints{Min: Bound(1), Max: Bound(10)}}
+	c := def.(ConstrainedDefinition).NumericConstraints()
+	if *c.Min != 1 || *c.Max != 10 || c.Policy != RangeReject {
+		t.Errorf("unexpected constraints %+v", c)
+	}
+}
+
+func TestUnits(t *testing.T) {
+	tests := []struct {
+		In       float64
+		From     Unit
+		To       Unit
+		Expected float64
+		Err      error
+	}{
+		{0, Celsius, Fahrenheit, 32, nil},
+		{100, Celsius, Kelvin, 373.15, nil},
+		{212, Fahrenheit, Celsius, 100, nil},
+		{1.5, Kilowatt, Watt, 1500, nil},
+		{250, Millivolt, Volt, 0.25, nil},
+		{50, Percent, Ratio, 0.5, nil},
+		{90, Minute, Hour, 1.5, nil},
+		{1, Watt, Volt, 0, ErrIncompatibleUnit{}},
+		{1, Unit{}, Watt, 0, ErrIncompatibleUnit{}},
+	}
+	for i, test := range tests {
+		v, err := test.From.Convert(test.In, test.To)
+		if test.Err != nil {
+			if err == nil || fmt.Sprintf("%T", err) != fmt.Sprintf("%T", test.Err) {
+				t.Errorf("%d %v %s to %s expected %T got value:%v err:%v", i, test.In, test.From, test.To, test.Err, v, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d %v %s to %s unexpected error %s", i, test.In, test.From, test.To, err)
+			continue
+		}
+		if math.Abs(v-test.Expected) > 1e-9 {
+			t.Errorf("%d %v %s to %s expected %v got %v", i, test.In, test.From, test.To, test.Expected, v)
+		}
+	}
+
+	def := DoubleDefinition{Unit: Celsius}
+	inputs := []struct {
+		In       interface{}
+		Expected float64
+		Err      error
+	}{
+		{21.5, 21.5, nil},
+		{"21.5", 21.5, nil},
+		{"21.5 °C", 21.5, nil},
+		{"212 F", 100, nil},
+		{"212°F", 100, nil},
+		{Quantity{Value: 273.15, Unit: Kelvin}, 0, nil},
+		{"5 W", 0, ErrIncompatibleUnit{}},
+		{"5 furlongs", 0, ErrUnknownUnit{}},
+		{Quantity{Value: 5, Unit: Watt}, 0, ErrIncompatibleUnit{}},
+	}
+	for i, test := range inputs {
+		v, err := def.ValidateAndTransform(test.In)
+		if test.Err != nil {
+			if err == nil || fmt.Sprintf("%T", err) != fmt.Sprintf("%T", test.Err) {
+				t.Errorf("%d input:%#v expected %T got value:%v err:%v", i, test.In, test.Err, v, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d input:%#v unexpected error %s", i, test.In, err)
+			continue
+		}
+		if math.Abs(v.(float64)-test.Expected) > 1e-9 {
+			t.Errorf("%d input:%#v expected %v got %v", i, test.In, test.Expected, v)
+		}
+	}
+	if s := def.Inspect(21.5); s != "21.5 °C" {
+		t.Errorf("expected the unit to be inspected got '%s'", s)
+	}
+	if _, err := (DoubleDefinition{}).ValidateAndTransform("3 kW"); err == nil {
+		t.Errorf("expected a quantity to be rejected by a definition without a unit")
+	}
+}
+
+func TestSubscriptionUnit(t *testing.T) {
+	var got []Value
+	broker := &Broker{}
+	node := BasicNode{
+		ID: "thermostat",
+		Attributes: []Attribute{
+			{Name: "temperature", Definition: DoubleDefinition{Unit: Celsius}},
+			{Name: "mode", Definition: StringDefinition{}},
+		},
+		Subscriptions: []Subscription{
+			{
+				Name:   "display",
+				Filter: "thermostat.*",
+				Unit:   Fahrenheit,
+				Fn: func(ctx Context, v Value) {
+					got = append(got, v)
+				},
+			},
+		},
+	}
+	if err := broker.Register(node); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.Publish(node, "thermostat.temperature", "68 °F"); err != nil {
+		t.Fatal(err)
+	}
+	v, err := broker.Value("thermostat.temperature", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Unit != Celsius || math.Abs(v.Value.Value.(float64)-20) > 1e-9 {
+		t.Errorf("expected the value to be stored in celsius got %v %s", v.Value.Value, v.Unit)
+	}
+	// the first value is the default published by register
+	if len(got) != 2 || got[1].Unit != Fahrenheit || math.Abs(got[1].Value.(float64)-68) > 1e-9 {
+		t.Fatalf("expected the subscription to see fahrenheit got %+v", got)
+	}
+
+	if err := broker.Publish(node, "thermostat.mode", "heat"); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Errorf("expected a value without a unit to be skipped got %+v", got)
+	}
+	rec, err := broker.Value("thermostat.mode", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.SubscriptionResponses) != 1 || len(rec.SubscriptionResponses[0].Err) != 1 {
+		t.Errorf("expected the conversion error to be reported got %+v", rec.SubscriptionResponses)
+	}
+}
+
+func TestObjectDefinition(t *testing.T) {
+	color := DoubleDefinition{Constraints: Constraints{Min: Bound(0), Max: Bound(255), Step: 1, Precision: 0}}
+	def := ObjectDefinition{
+		Fields: []Field{
+			{Name: "r", Definition: color, Required: true},
+			{Name: "g", Definition: color, Required: true},
+			{Name: "b", Definition: color, Required: true},
+			{Name: "name", Definition: StringDefinition{}},
+		},
+	}
+
+	tests := []struct {
+		In       interface{}
+		Expected string
+		Err      error
+	}{
+		{map[string]interface{}{"r": 255, "g": 0, "b": 10.0}, "{r: 255, g: 0, b: 10, name: }", nil},
+		{`{"b": 1, "g": 2, "r": 3, "name": "teal"}`, "{r: 3, g: 2, b: 1, name: teal}", nil},
+		{[]byte(`{"r": "1", "g": 2, "b": 3}`), "{r: 1, g: 2, b: 3, name: }", nil},
+		{map[string]interface{}{"r": 256, "g": 0, "b": 0}, "", ErrInvalidField{}},
+		{map[string]interface{}{"r": 1, "g": 0}, "", ErrMissingField{}},
+		{map[string]interface{}{"r": 1, "g": 0, "b": 0, "a": 1}, "", ErrUnknownField{}},
+		{`[1, 2, 3]`, "", ErrInvalidType{}},
+		{42, "", ErrInvalidType{}},
+		{nil, "", ErrInvalidType{}},
+	}
+	for i, test := range tests {
+		v, err := def.ValidateAndTransform(test.In)
+		if test.Err != nil {
+			if err == nil || fmt.Sprintf("%T", err) != fmt.Sprintf("%T", test.Err) {
+				t.Errorf("%d input:%#v expected %T got value:%v err:%v", i, test.In, test.Err, v, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d input:%#v unexpected error %s", i, test.In, err)
+			continue
+		}
+		if s := def.Inspect(v); s != test.Expected {
+			t.Errorf("%d input:%#v expected '%s' got '%s'", i, test.In, test.Expected, s)
+		}
+	}
+
+	_, err := def.ValidateAndTransform(map[string]interface{}{"r": 1, "g": "x", "b": 0})
+	var fieldErr ErrInvalidField
+	if !errors.As(err, &fieldErr) || fieldErr.Field != "g" || !errors.As(err, new(*strconv.NumError)) {
+		t.Errorf("expected the field error to wrap the cause got %v", err)
+	}
+}
+
+func TestArrayDefinition(t *testing.T) {
+	def := ArrayDefinition{Element: StringDefinition{}, MinLength: 1, MaxLength: 3}
+
+	tests := []struct {
+		In       interface{}
+		Expected string
+		Err      error
+	}{
+		{[]interface{}{"home", "guest"}, "[home, guest]", nil},
+		{[]string{"home"}, "[home]", nil},
+		{`["a", "b", "c"]`, "[a, b, c]", nil},
+		{[]interface{}{}, "", ErrInvalidLength{}},
+		{[]string{"a", "b", "c", "d"}, "", ErrInvalidLength{}},
+		{[]interface{}{"a", map[string]interface{}{}}, "", ErrInvalidElement{}},
+		{`{"a": 1}`, "", ErrInvalidType{}},
+		{"home", "", ErrInvalidType{}},
+	}
+	for i, test := range tests {
+		v, err := def.ValidateAndTransform(test.In)
+		if test.Err != nil {
+			if err == nil || fmt.Sprintf("%T", err) != fmt.Sprintf("%T", test.Err) {
+				t.Errorf("%d input:%#v expected %T got value:%v err:%v", i, test.In, test.Err, v, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d input:%#v unexpected error %s", i, test.In, err)
+			continue
+		}
+		if s := def.Inspect(v); s != test.Expected {
+			t.Errorf("%d input:%#v expected '%s' got '%s'", i, test.In, test.Expected, s)
+		}
+	}
+
+	if v := def.DefaultValue().([]interface{}); len(v) != 1 || v[0] != "" {
+		t.Errorf("expected MinLength default elements got %#v", v)
+	}
+
+	schedule := ArrayDefinition{Element: ObjectDefinition{Fields: []Field{
+		{Name: "at", Definition: StringDefinition{}, Required: true},
+		{Name: "on", Definition: BooleanDefinition{}},
+	}}}
+	v, err := schedule.ValidateAndTransform(`[{"at": "07:00", "on": true}, {"at": "22:00"}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := schedule.Inspect(v); s != "[{at: 07:00, on: true}, {at: 22:00, on: false}]" {
+		t.Errorf("unexpected inspect '%s'", s)
+	}
+}
+
+func TestStructuredAccept(t *testing.T) {
+	var accepted []interface{}
+	def := ObjectDefinition{
+		Fields: []Field{
+			{Name: "ssid", Definition: StringDefinition{AcceptFn: func(v string) error {
+				if v == "" {
+					return errors.New("empty ssid")
+				}
+				return nil
+			}}},
+		},
+		AcceptFn: func(v map[string]interface{}) error {
+			accepted = append(accepted, v)
+			return nil
+		},
+	}
+	broker := &Broker{}
+	node := BasicNode{ID: "wifi", Attributes: []Attribute{{Name: "network", Definition: def}}}
+	app := BasicNode{ID: "app"}
+	if err := broker.Register(node); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.Publish(app, "wifi.network", `{"ssid": ""}`); err == nil {
+		t.Error("expected the field accept to reject an empty ssid")
+	}
+	if len(accepted) != 0 {
+		t.Errorf("expected AcceptFn not to run after a field rejected the value got %v", accepted)
+	}
+	if err := broker.Publish(app, "wifi.network", `{"ssid": "home"}`); err != nil {
+		t.Fatal(err)
+	}
+	if len(accepted) != 1 || accepted[0].(map[string]interface{})["ssid"] != "home" {
+		t.Errorf("expected AcceptFn to see the transformed value got %v", accepted)
+	}
+}
+
+func TestTimeDefinition(t *testing.T) {
+	lenient, strict := TimeDefinition{}, TimeDefinition{Coercion: CoerceStrict}
+	boot := time.Date(2021, 6, 1, 12, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		Def      Definition
+		In       interface{}
+		Expected string
+		Err      error
+	}{
+		{lenient, boot, "2021-06-01T12:30:00Z", nil},
+		{lenient, boot.In(time.FixedZone("PDT", -7*3600)), "2021-06-01T12:30:00Z", nil},
+		{lenient, "2021-06-01T05:30:00-07:00", "2021-06-01T12:30:00Z", nil},
+		{lenient, "2021-06-01T12:30:00.25Z", "2021-06-01T12:30:00.25Z", nil},
+		{lenient, " 2021-06-01 12:30:00 ", "2021-06-01T12:30:00Z", nil},
+		{lenient, "2021-06-01", "2021-06-01T00:00:00Z", nil},
+		{lenient, "1622550600", "2021-06-01T12:30:00Z", nil},
+		{lenient, 1622550600, "2021-06-01T12:30:00Z", nil},
+		{lenient, uint32(1622550600), "2021-06-01T12:30:00Z", nil},
+		{lenient, 1622550600.5, "2021-06-01T12:30:00.5Z", nil},
+		{lenient, "last tuesday", "", &time.ParseError{}},
+		{lenient, math.Inf(1), "", ErrLossyConversion{}},
+		{lenient, true, "", ErrInvalidType{}},
+		{strict, "2021-06-01T12:30:00Z", "2021-06-01T12:30:00Z", nil},
+		{strict, 1622550600.0, "2021-06-01T12:30:00Z", nil},
+		{strict, 1622550600.5, "", ErrLossyConversion{}},
+		{strict, "2021-06-01", "", &time.ParseError{}},
+		{strict, "1622550600", "", &time.ParseError{}},
+	}
+	for i, test := range tests {
+		v, err := test.Def.ValidateAndTransform(test.In)
+		if test.Err != nil {
+			if err == nil || fmt.Sprintf("%T", err) != fmt.Sprintf("%T", test.Err) {
+				t.Errorf("%d input:%#v expected %T got value:%v err:%v", i, test.In, test.Err, v, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d input:%#v unexpected error %s", i, test.In, err)
+			continue
+		}
+		if s := test.Def.Inspect(v); s != test.Expected {
+			t.Errorf("%d input:%#v expected '%s' got '%s'", i, test.In, test.Expected, s)
+		}
+	}
+}
+
+func TestDurationDefinition(t *testing.T) {
+	lenient, strict := DurationDefinition{}, DurationDefinition{Coercion: CoerceStrict}
+	millis := DurationDefinition{NumberUnit: time.Millisecond}
+
+	tests := []struct {
+		Def      DurationDefinition
+		In       interface{}
+		Expected time.Duration
+		Err      error
+	}{
+		{lenient, 90 * time.Second, 90 * time.Second, nil},
+		{lenient, "1m30s", 90 * time.Second, nil},
+		{lenient, " 250ms ", 250 * time.Millisecond, nil},
+		{lenient, "90", 90 * time.Second, nil},
+		{lenient, 90, 90 * time.Second, nil},
+		{lenient, uint8(90), 90 * time.Second, nil},
+		{lenient, 1.5, 1500 * time.Millisecond, nil},
+		{lenient, 1e-10, 0, nil},
+		{lenient, int64(math.MaxInt64), 0, ErrLossyConversion{}},
+		{lenient, 1e300, 0, ErrLossyConversion{}},
+		{lenient, "soon", 0, &time.ParseError{}},
+		{lenient, true, 0, ErrInvalidType{}},
+		{millis, 1500, 1500 * time.Millisecond, nil},
+		{millis, "1500", 1500 * time.Millisecond, nil},
+		{strict, "1m30s", 90 * time.Second, nil},
+		{strict, 90, 90 * time.Second, nil},
+		{strict, 1.5, 1500 * time.Millisecond, nil},
+		{strict, 1e-10, 0, ErrLossyConversion{}},
+		{strict, "90", 0, &time.ParseError{}},
+	}
+	for i, test := range tests {
+		v, err := test.Def.ValidateAndTransform(test.In)
+		if test.Err != nil {
+			// time.ParseDuration returns a plain error so any error will do for those
+			_, parse := test.Err.(*time.ParseError)
+			if err == nil || (!parse && fmt.Sprintf("%T", err) != fmt.Sprintf("%T", test.Err)) {
+				t.Errorf("%d input:%#v expected %T got value:%v err:%v", i, test.In, test.Err, v, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d input:%#v unexpected error %s", i, test.In, err)
+			continue
+		}
+		if v != test.Expected {
+			t.Errorf("%d input:%#v expected %v got %v", i, test.In, test.Expected, v)
+		}
+	}
+	if s := lenient.Inspect(90 * time.Second); s != "1m30s" {
+		t.Errorf("unexpected inspect '%s'", s)
+	}
+}
+
+func TestBytesDefinition(t *testing.T) {
+	lenient, strict := BytesDefinition{MaxSize: 4}, BytesDefinition{Coercion: CoerceStrict}
+
+	tests := []struct {
+		Def      BytesDefinition
+		In       interface{}
+		Expected string
+		Err      error
+	}{
+		{lenient, []byte{0xde, 0xad}, "3q0=", nil},
+		{lenient, "3q0=", "3q0=", nil},
+		{lenient, "3q0", "3q0=", nil},
+		{lenient, "_-8=", "/+8=", nil},
+		{lenient, "0xdead", "3q0=", nil},
+		{lenient, "0xAg", "0xAg", nil},
+		{lenient, "", "", nil},
+		{lenient, []byte{1, 2, 3, 4, 5}, "", ErrInvalidLength{}},
+		{lenient, "0xdeadbeef00", "", ErrInvalidLength{}},
+		{lenient, "0xbeefy", "", hex.InvalidByteError(0)},
+		{lenient, "!!", "", base64.CorruptInputError(0)},
+		{lenient, 42, "", ErrInvalidType{}},
+		{strict, "3q0=", "3q0=", nil},
+		{strict, "3q0", "", base64.CorruptInputError(0)},
+		{strict, "0xdead", "", base64.CorruptInputError(0)},
+	}
+	for i, test := range tests {
+		v, err := test.Def.ValidateAndTransform(test.In)
+		if test.Err != nil {
+			if err == nil || fmt.Sprintf("%T", err) != fmt.Sprintf("%T", test.Err) {
+				t.Errorf("%d input:%#v expected %T got value:%v err:%v", i, test.In, test.Err, v, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d input:%#v unexpected error %s", i, test.In, err)
+			continue
+		}
+		if s := test.Def.Inspect(v); s != test.Expected {
+			t.Errorf("%d input:%#v expected '%s' got '%s'", i, test.In, test.Expected, s)
+		}
+	}
+
+	in := []byte{1, 2}
+	v, _ := lenient.ValidateAndTransform(in)
+	in[0] = 9
+	if v.([]byte)[0] != 1 {
+		t.Error("expected the published slice to be copied")
+	}
+}
+
+func TestBytesRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, def := range []BytesDefinition{{}, {Coercion: CoerceStrict}} {
+		for i := 0; i < 1000; i++ {
+			b := make([]byte, r.Intn(16))
+			r.Read(b)
+			v, err := def.ValidateAndTransform(def.Inspect(b))
+			if err != nil || !bytes.Equal(v.([]byte), b) {
+				t.Fatalf("%v inspected as '%s' came back as %v err:%v", b, def.Inspect(b), v, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// ArrayDefinition is a list of values of the same Element definition, a value is a []interface{}.
+// It can be published as any slice or as a JSON array
+type ArrayDefinition struct {
+	Element   Definition
+	MinLength int
+	// MaxLength of zero means unbounded
+	MaxLength       int
+	AcceptFn        func(v []interface{}) error
+	AcceptContextFn func(ctx context.Context, v []interface{}) error
+}
+
+func (d ArrayDefinition) ValidateAndTransform(v interface{}) (interface{}, error) {
+	var in []interface{}
+	switch i := v.(type) {
+	case []interface{}:
+		in = i
+	case string:
+		if err := json.Unmarshal([]byte(i), &in); err != nil || in == nil {
+			return nil, ErrInvalidType{Expected: reflect.Slice, Actual: reflect.String}
+		}
+	case []byte:
+		if err := json.Unmarshal(i, &in); err != nil || in == nil {
+			return nil, ErrInvalidType{Expected: reflect.Slice, Actual: reflect.Slice}
+		}
+	default:
+		rv := reflect.ValueOf(v)
+		if kindOf(v) != reflect.Slice && kindOf(v) != reflect.Array {
+			return nil, ErrInvalidType{Expected: reflect.Slice, Actual: kindOf(v)}
+		}
+		in = make([]interface{}, rv.Len())
+		for n := range in {
+			in[n] = rv.Index(n).Interface()
+		}
+	}
+
+	if len(in) < d.MinLength || (d.MaxLength > 0 && len(in) > d.MaxLength) {
+		return nil, ErrInvalidLength{Length: len(in), MinLength: d.MinLength, MaxLength: d.MaxLength}
+	}
+	out := make([]interface{}, len(in))
+	for n, ev := range in {
+		val, err := d.Element.ValidateAndTransform(ev)
+		if err != nil {
+			return nil, ErrInvalidElement{Index: n, Err: err}
+		}
+		out[n] = val
+	}
+	return out, nil
+}
+
+func (d ArrayDefinition) Inspect(v interface{}) string {
+	s, ok := v.([]interface{})
+	if !ok {
+		return ""
+	}
+	parts := make([]string, len(s))
+	for n, ev := range s {
+		parts[n] = d.Element.Inspect(ev)
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+// EncodeValue encodes every element when the element definition needs it, see EncodingDefinition
+func (d ArrayDefinition) EncodeValue(v interface{}) interface{} {
+	s, ok := v.([]interface{})
+	if !ok {
+		return v
+	}
+	out := make([]interface{}, len(s))
+	for n, ev := range s {
+		out[n] = encodeValue(d.Element, ev)
+	}
+	return out
+}
+
+// DefaultValue holds MinLength default elements
+func (d ArrayDefinition) DefaultValue() interface{} {
+	out := make([]interface{}, d.MinLength)
+	for n := range out {
+		out[n] = d.Element.DefaultValue()
+	}
+	return out
+}
+
+// Accept runs the Accept of the element definition on every element before AcceptFn
+func (d ArrayDefinition) Accept(v interface{}) error {
+	return d.AcceptContext(context.Background(), v)
+}
+
+func (d ArrayDefinition) AcceptContext(ctx context.Context, v interface{}) error {
+	s := v.([]interface{})
+	for n, ev := range s {
+		if err := accept(ctx, d.Element, ev); err != nil {
+			return ErrInvalidElement{Index: n, Err: err}
+		}
+	}
+	if d.AcceptContextFn != nil {
+		return d.AcceptContextFn(ctx, s)
+	}
+	if d.AcceptFn != nil {
+		return acceptWithin(ctx, func(interface{}) error { return d.AcceptFn(s) }, v)
+	}
+	return nil
+}
@@ -1,49 +1,23 @@
 package pubsub
 
 import (
-	"fmt"
-	"reflect"
+	"context"
+	"strconv"
 )
 
 type BooleanDefinition struct {
-	AcceptFn func(v bool) error
+	Coercion        Coercion
+	AcceptFn        func(v bool) error
+	AcceptContextFn func(ctx context.Context, v bool) error
 }
 
 func (d BooleanDefinition) ValidateAndTransform(v interface{}) (interface{}, error) {
-	var val bool
-	switch i := v.(type) {
-	case int:
-		val = i > 0
-		return val, nil
-	case int8:
-		val = i > 0
-		return val, nil
-	case int16:
-		val = i > 0
-		return val, nil
-	case int32:
-		val = i > 0
-		return val, nil
-	case int64:
-		val = i > 0
-		return val, nil
-	case float32:
-		val = i > 0
-		return val, nil
-	case float64:
-		val = i > 0
-		return val, nil
-	case bool:
-		return i, nil
-	case string:
-		return i == "true", nil
-	}
-	return nil, ErrInvalidType{Expected: reflect.Bool, Actual: reflect.TypeOf(v).Kind()}
+	return coerceBool(v, d.Coercion)
 }
 
 func (d BooleanDefinition) Inspect(v interface{}) string {
 	if s, ok := v.(bool); ok {
-		return fmt.Sprintf("%t", s)
+		return strconv.FormatBool(s)
 	}
 	return ""
 }
@@ -52,8 +26,18 @@ func (d BooleanDefinition) DefaultValue() interface{} {
 	return false
 }
 func (d BooleanDefinition) Accept(v interface{}) error {
+	if d.AcceptContextFn != nil {
+		return d.AcceptContextFn(context.Background(), v.(bool))
+	}
 	if d.AcceptFn != nil {
 		return d.AcceptFn(v.(bool))
 	}
 	return nil
 }
+
+func (d BooleanDefinition) AcceptContext(ctx context.Context, v interface{}) error {
+	if d.AcceptContextFn != nil {
+		return d.AcceptContextFn(ctx, v.(bool))
+	}
+	return acceptWithin(ctx, d.Accept, v)
+}
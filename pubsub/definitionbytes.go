@@ -0,0 +1,89 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"reflect"
+	"strings"
+)
+
+// BytesDefinition is a small binary payload. It can be published as a []byte or a base64 string,
+// lenient mode also takes unpadded or url safe base64 and hex prefixed with 0x, a string that is valid base64
+// is always read as base64. It is inspected as base64
+type BytesDefinition struct {
+	// MaxSize of zero means unbounded
+	MaxSize         int
+	Coercion        Coercion
+	AcceptFn        func(v []byte) error
+	AcceptContextFn func(ctx context.Context, v []byte) error
+}
+
+func (d BytesDefinition) decode(s string) ([]byte, error) {
+	if d.Coercion == CoerceStrict {
+		return base64.StdEncoding.DecodeString(s)
+	}
+	// canonical base64 goes first so whatever Inspect returned decodes to the same bytes, even when it starts with '0x'
+	s = strings.TrimSpace(s)
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err == nil {
+		return b, nil
+	}
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		return hex.DecodeString(s[2:])
+	}
+	for _, enc := range []*base64.Encoding{base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if b, encErr := enc.DecodeString(s); encErr == nil {
+			return b, nil
+		}
+	}
+	return nil, err
+}
+
+func (d BytesDefinition) ValidateAndTransform(v interface{}) (interface{}, error) {
+	var b []byte
+	switch i := v.(type) {
+	case []byte:
+		b = append([]byte{}, i...)
+	case string:
+		decoded, err := d.decode(i)
+		if err != nil {
+			return nil, err
+		}
+		b = decoded
+	default:
+		return nil, ErrInvalidType{Expected: reflect.Slice, Actual: kindOf(v)}
+	}
+	if d.MaxSize > 0 && len(b) > d.MaxSize {
+		return nil, ErrInvalidLength{Length: len(b), MaxLength: d.MaxSize}
+	}
+	return b, nil
+}
+
+func (d BytesDefinition) Inspect(v interface{}) string {
+	if b, ok := v.([]byte); ok {
+		return base64.StdEncoding.EncodeToString(b)
+	}
+	return ""
+}
+
+func (d BytesDefinition) DefaultValue() interface{} {
+	return []byte{}
+}
+
+func (d BytesDefinition) Accept(v interface{}) error {
+	if d.AcceptContextFn != nil {
+		return d.AcceptContextFn(context.Background(), v.([]byte))
+	}
+	if d.AcceptFn != nil {
+		return d.AcceptFn(v.([]byte))
+	}
+	return nil
+}
+
+func (d BytesDefinition) AcceptContext(ctx context.Context, v interface{}) error {
+	if d.AcceptContextFn != nil {
+		return d.AcceptContextFn(ctx, v.([]byte))
+	}
+	return acceptWithin(ctx, d.Accept, v)
+}
@@ -1,57 +1,69 @@
 package pubsub
 
 import (
-	"reflect"
+	"context"
 	"strconv"
 )
 
 type DoubleDefinition struct {
-	AcceptFn func(v float64) error
+	Constraints
+	// Unit is the unit values are kept in, a Quantity in a compatible unit is converted to it
+	Unit            Unit
+	Coercion        Coercion
+	AcceptFn        func(v float64) error
+	AcceptContextFn func(ctx context.Context, v float64) error
+}
+
+func (d DoubleDefinition) NumericConstraints() Constraints {
+	return d.Constraints
+}
+
+func (d DoubleDefinition) MeasurementUnit() Unit {
+	return d.Unit
 }
 
 func (d DoubleDefinition) ValidateAndTransform(v interface{}) (interface{}, error) {
-	var val float64
-	switch i := v.(type) {
-	case int:
-		val = float64(i)
-		return val, nil
-	case int8:
-		val = float64(i)
-		return val, nil
-	case int16:
-		val = float64(i)
-		return val, nil
-	case int32:
-		val = float64(i)
-		return val, nil
-	case int64:
-		val = float64(i)
-		return val, nil
-	case float32:
-		val = float64(i)
-		return val, nil
-	case float64:
-		val = i
-		return val, nil
-	case string:
-		return strconv.ParseFloat(i, 64)
+	v, err := toUnit(v, d.Unit)
+	if err != nil {
+		return nil, err
+	}
+	val, err := d.transform(v)
+	if err != nil {
+		return nil, err
 	}
-	return nil, ErrInvalidType{Expected: reflect.Float64, Actual: reflect.TypeOf(v).Kind()}
+	return d.Constraints.apply(val.(float64))
+}
+
+func (d DoubleDefinition) transform(v interface{}) (interface{}, error) {
+	return coerceFloat(v, d.Coercion)
 }
 
 func (d DoubleDefinition) Inspect(v interface{}) string {
 	if s, ok := v.(float64); ok {
-		return strconv.FormatFloat(s, 'f', 4, 64)
+		if d.Precision > 0 {
+			return inspectUnit(strconv.FormatFloat(s, 'f', d.Precision, 64), d.Unit)
+		}
+		return inspectUnit(strconv.FormatFloat(s, 'f', -1, 64), d.Unit)
 	}
 	return ""
 }
 
 func (d DoubleDefinition) DefaultValue() interface{} {
-	return 0.0
+	return d.Constraints.defaultValue()
 }
 func (d DoubleDefinition) Accept(v interface{}) error {
+	if d.AcceptContextFn != nil {
+		return d.AcceptContextFn(context.Background(), v.(float64))
+	}
 	if d.AcceptFn != nil {
 		return d.AcceptFn(v.(float64))
 	}
 	return nil
 }
+
+func (d DoubleDefinition) AcceptContext(ctx context.Context, v interface{}) error {
+	if d.AcceptContextFn != nil {
+		return d.AcceptContextFn(ctx, v.(float64))
+	}
+	return acceptWithin(ctx, d.Accept, v)
+}
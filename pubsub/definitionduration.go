@@ -0,0 +1,109 @@
+package pubsub
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DurationDefinition is a time.Duration. It can be published as a time.Duration, a string in
+// Go duration syntax such as "1m30s" or a plain number of NumberUnit, and is inspected in Go duration syntax
+type DurationDefinition struct {
+	// NumberUnit is what a plain number counts, zero means seconds
+	NumberUnit      time.Duration
+	Coercion        Coercion
+	AcceptFn        func(v time.Duration) error
+	AcceptContextFn func(ctx context.Context, v time.Duration) error
+}
+
+func (d DurationDefinition) numberUnit() time.Duration {
+	if d.NumberUnit <= 0 {
+		return time.Second
+	}
+	return d.NumberUnit
+}
+
+func (d DurationDefinition) fromNumber(f float64, v interface{}) (time.Duration, error) {
+	ns := f * float64(d.numberUnit())
+	if d.Coercion != CoerceStrict {
+		ns = math.Round(ns)
+	}
+	if math.Trunc(ns) != ns || ns < math.MinInt64 || ns >= math.MaxInt64 {
+		return 0, ErrLossyConversion{Value: v, Expected: reflect.Int64}
+	}
+	return time.Duration(ns), nil
+}
+
+func (d DurationDefinition) ValidateAndTransform(v interface{}) (interface{}, error) {
+	switch i := v.(type) {
+	case time.Duration:
+		return i, nil
+	case string:
+		s := i
+		if d.Coercion != CoerceStrict {
+			s = strings.TrimSpace(s)
+		}
+		dur, err := time.ParseDuration(s)
+		if err == nil || d.Coercion == CoerceStrict {
+			return dur, err
+		}
+		if f, ferr := strconv.ParseFloat(s, 64); ferr == nil {
+			return d.fromNumber(f, v)
+		}
+		return nil, err
+	}
+	rv := reflect.ValueOf(v)
+	switch kindOf(v) {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, unit := rv.Int(), int64(d.numberUnit())
+		if n > math.MaxInt64/unit || n < math.MinInt64/unit {
+			return nil, ErrLossyConversion{Value: v, Expected: reflect.Int64}
+		}
+		return time.Duration(n * unit), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if rv.Uint() > uint64(math.MaxInt64/int64(d.numberUnit())) {
+			return nil, ErrLossyConversion{Value: v, Expected: reflect.Int64}
+		}
+		return time.Duration(int64(rv.Uint()) * int64(d.numberUnit())), nil
+	case reflect.Float32, reflect.Float64:
+		return d.fromNumber(rv.Float(), v)
+	}
+	return nil, ErrInvalidType{Expected: reflect.Int64, Actual: kindOf(v)}
+}
+
+func (d DurationDefinition) Inspect(v interface{}) string {
+	if dur, ok := v.(time.Duration); ok {
+		return dur.String()
+	}
+	return ""
+}
+
+// EncodeValue keeps a duration in Go duration syntax, a bare number of nanoseconds would be
+// read back as NumberUnit
+func (d DurationDefinition) EncodeValue(v interface{}) interface{} {
+	return d.Inspect(v)
+}
+
+func (d DurationDefinition) DefaultValue() interface{} {
+	return time.Duration(0)
+}
+
+func (d DurationDefinition) Accept(v interface{}) error {
+	if d.AcceptContextFn != nil {
+		return d.AcceptContextFn(context.Background(), v.(time.Duration))
+	}
+	if d.AcceptFn != nil {
+		return d.AcceptFn(v.(time.Duration))
+	}
+	return nil
+}
+
+func (d DurationDefinition) AcceptContext(ctx context.Context, v interface{}) error {
+	if d.AcceptContextFn != nil {
+		return d.AcceptContextFn(ctx, v.(time.Duration))
+	}
+	return acceptWithin(ctx, d.Accept, v)
+}
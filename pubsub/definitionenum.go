@@ -0,0 +1,99 @@
+package pubsub
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// EnumDefinition is a string attribute restricted to a fixed set of labels, a value can be
+// published either as its label or as its index in Values
+type EnumDefinition struct {
+	Values []string
+	// Default must be one of Values, the first value is used when it is empty
+	Default         string
+	AcceptFn        func(v string) error
+	AcceptContextFn func(ctx context.Context, v string) error
+}
+
+// Options lists the allowed labels in index order
+func (d EnumDefinition) Options() []string {
+	return append([]string(nil), d.Values...)
+}
+
+func (d EnumDefinition) index(i int64) (interface{}, error) {
+	if i < 0 || i >= int64(len(d.Values)) {
+		return nil, ErrInvalidEnumValue{Value: i, Options: d.Options()}
+	}
+	return d.Values[i], nil
+}
+
+func (d EnumDefinition) ValidateAndTransform(v interface{}) (interface{}, error) {
+	switch i := v.(type) {
+	case int:
+		return d.index(int64(i))
+	case int8:
+		return d.index(int64(i))
+	case int16:
+		return d.index(int64(i))
+	case int32:
+		return d.index(int64(i))
+	case int64:
+		return d.index(i)
+	case float32:
+		if float32(math.Trunc(float64(i))) == i {
+			return d.index(int64(i))
+		}
+	case float64:
+		if math.Trunc(i) == i {
+			return d.index(int64(i))
+		}
+	case string:
+		for _, label := range d.Values {
+			if label == i {
+				return label, nil
+			}
+		}
+		if idx, err := strconv.ParseInt(i, 10, 64); err == nil {
+			return d.index(idx)
+		}
+	default:
+		return nil, ErrInvalidType{Expected: reflect.String, Actual: kindOf(v)}
+	}
+	return nil, ErrInvalidEnumValue{Value: v, Options: d.Options()}
+}
+
+func (d EnumDefinition) Inspect(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return ""
+}
+
+func (d EnumDefinition) DefaultValue() interface{} {
+	if d.Default != "" {
+		return d.Default
+	}
+	if len(d.Values) > 0 {
+		return d.Values[0]
+	}
+	return ""
+}
+
+func (d EnumDefinition) Accept(v interface{}) error {
+	if d.AcceptContextFn != nil {
+		return d.AcceptContextFn(context.Background(), v.(string))
+	}
+	if d.AcceptFn != nil {
+		return d.AcceptFn(v.(string))
+	}
+	return nil
+}
+
+func (d EnumDefinition) AcceptContext(ctx context.Context, v interface{}) error {
+	if d.AcceptContextFn != nil {
+		return d.AcceptContextFn(ctx, v.(string))
+	}
+	return acceptWithin(ctx, d.Accept, v)
+}
@@ -1,57 +1,71 @@
 package pubsub
 
 import (
-	"reflect"
+	"context"
+	"math"
 	"strconv"
 )
 
 type IntegerDefinition struct {
-	AcceptFn func(v int64) error
+	Constraints
+	// Unit is the unit values are kept in, a Quantity in a compatible unit is converted to it
+	Unit            Unit
+	Coercion        Coercion
+	AcceptFn        func(v int64) error
+	AcceptContextFn func(ctx context.Context, v int64) error
+}
+
+func (d IntegerDefinition) NumericConstraints() Constraints {
+	return d.Constraints
+}
+
+func (d IntegerDefinition) MeasurementUnit() Unit {
+	return d.Unit
 }
 
 func (d IntegerDefinition) ValidateAndTransform(v interface{}) (interface{}, error) {
-	var val int64
-	switch i := v.(type) {
-	case int:
-		val = int64(i)
-		return val, nil
-	case int8:
-		val = int64(i)
-		return val, nil
-	case int16:
-		val = int64(i)
-		return val, nil
-	case int32:
-		val = int64(i)
-		return val, nil
-	case int64:
-		val = i
-		return val, nil
-	case float32:
-		val = int64(i)
-		return val, nil
-	case float64:
-		val = int64(i)
-		return val, nil
-	case string:
-		return strconv.ParseInt(i, 10, 64)
+	v, err := toUnit(v, d.Unit)
+	if err != nil {
+		return nil, err
+	}
+	val, err := d.transform(v)
+	if err != nil || d.Constraints.isZero() {
+		return val, err
+	}
+	c, err := d.Constraints.applyInt(val.(int64))
+	if err != nil {
+		return nil, err
 	}
-	return nil, ErrInvalidType{Expected: reflect.Int64, Actual: reflect.TypeOf(v).Kind()}
+	return c, nil
+}
+
+func (d IntegerDefinition) transform(v interface{}) (interface{}, error) {
+	return coerceInt(v, d.Coercion)
 }
 
 func (d IntegerDefinition) Inspect(v interface{}) string {
 	if s, ok := v.(int64); ok {
-		return strconv.FormatInt(s, 64)
+		return inspectUnit(strconv.FormatInt(s, 10), d.Unit)
 	}
 	return ""
 }
 
 func (d IntegerDefinition) DefaultValue() interface{} {
-	return int64(0)
+	return int64(math.Round(d.Constraints.defaultValue()))
 }
 func (d IntegerDefinition) Accept(v interface{}) error {
+	if d.AcceptContextFn != nil {
+		return d.AcceptContextFn(context.Background(), v.(int64))
+	}
 	if d.AcceptFn != nil {
 		return d.AcceptFn(v.(int64))
 	}
 	return nil
 }
+
+func (d IntegerDefinition) AcceptContext(ctx context.Context, v interface{}) error {
+	if d.AcceptContextFn != nil {
+		return d.AcceptContextFn(ctx, v.(int64))
+	}
+	return acceptWithin(ctx, d.Accept, v)
+}
@@ -0,0 +1,136 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// Field is one named member of an ObjectDefinition
+type Field struct {
+	Name       string
+	Definition Definition
+	// Required fields must be present, a missing optional field gets the default value of its definition
+	Required bool
+}
+
+// ObjectDefinition is a structured attribute made of named fields, a value is a map[string]interface{}
+// holding every field. It can be published as a map or as a JSON object
+type ObjectDefinition struct {
+	Fields          []Field
+	AcceptFn        func(v map[string]interface{}) error
+	AcceptContextFn func(ctx context.Context, v map[string]interface{}) error
+}
+
+func (d ObjectDefinition) field(name string) (Field, bool) {
+	for _, f := range d.Fields {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return Field{}, false
+}
+
+func (d ObjectDefinition) ValidateAndTransform(v interface{}) (interface{}, error) {
+	var in map[string]interface{}
+	switch i := v.(type) {
+	case map[string]interface{}:
+		in = i
+	case string:
+		if err := json.Unmarshal([]byte(i), &in); err != nil || in == nil {
+			return nil, ErrInvalidType{Expected: reflect.Map, Actual: reflect.String}
+		}
+	case []byte:
+		if err := json.Unmarshal(i, &in); err != nil || in == nil {
+			return nil, ErrInvalidType{Expected: reflect.Map, Actual: reflect.Slice}
+		}
+	default:
+		return nil, ErrInvalidType{Expected: reflect.Map, Actual: kindOf(v)}
+	}
+
+	for name := range in {
+		if _, ok := d.field(name); !ok {
+			return nil, ErrUnknownField{Field: name}
+		}
+	}
+	out := make(map[string]interface{}, len(d.Fields))
+	for _, f := range d.Fields {
+		fv, ok := in[f.Name]
+		if !ok {
+			if f.Required {
+				return nil, ErrMissingField{Field: f.Name}
+			}
+			out[f.Name] = f.Definition.DefaultValue()
+			continue
+		}
+		val, err := f.Definition.ValidateAndTransform(fv)
+		if err != nil {
+			return nil, ErrInvalidField{Field: f.Name, Err: err}
+		}
+		out[f.Name] = val
+	}
+	return out, nil
+}
+
+// Inspect renders the fields in the order they are defined
+func (d ObjectDefinition) Inspect(v interface{}) string {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	parts := make([]string, 0, len(d.Fields))
+	for _, f := range d.Fields {
+		parts = append(parts, f.Name+": "+f.Definition.Inspect(m[f.Name]))
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+// EncodeValue encodes the fields whose definitions need it, see EncodingDefinition
+func (d ObjectDefinition) EncodeValue(v interface{}) interface{} {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return v
+	}
+	out := make(map[string]interface{}, len(m))
+	for _, f := range d.Fields {
+		out[f.Name] = encodeValue(f.Definition, m[f.Name])
+	}
+	return out
+}
+
+func (d ObjectDefinition) DefaultValue() interface{} {
+	out := make(map[string]interface{}, len(d.Fields))
+	for _, f := range d.Fields {
+		out[f.Name] = f.Definition.DefaultValue()
+	}
+	return out
+}
+
+// Accept runs the Accept of every field before AcceptFn
+func (d ObjectDefinition) Accept(v interface{}) error {
+	return d.AcceptContext(context.Background(), v)
+}
+
+func (d ObjectDefinition) AcceptContext(ctx context.Context, v interface{}) error {
+	m := v.(map[string]interface{})
+	for _, f := range d.Fields {
+		if err := accept(ctx, f.Definition, m[f.Name]); err != nil {
+			return ErrInvalidField{Field: f.Name, Err: err}
+		}
+	}
+	if d.AcceptContextFn != nil {
+		return d.AcceptContextFn(ctx, m)
+	}
+	if d.AcceptFn != nil {
+		return acceptWithin(ctx, func(interface{}) error { return d.AcceptFn(m) }, v)
+	}
+	return nil
+}
+
+func kindOf(v interface{}) reflect.Kind {
+	if v == nil {
+		return reflect.Invalid
+	}
+	return reflect.TypeOf(v).Kind()
+}
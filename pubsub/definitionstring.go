@@ -1,16 +1,17 @@
 package pubsub
 
-import "reflect"
+import (
+	"context"
+)
 
 type StringDefinition struct {
-	AcceptFn func(v string) error
+	Coercion        Coercion
+	AcceptFn        func(v string) error
+	AcceptContextFn func(ctx context.Context, v string) error
 }
 
 func (d StringDefinition) ValidateAndTransform(v interface{}) (interface{}, error) {
-	if v, ok := v.(string); ok {
-		return v, nil
-	}
-	return nil, ErrInvalidType{Expected: reflect.String, Actual: reflect.TypeOf(v).Kind()}
+	return coerceString(v, d.Coercion)
 }
 
 func (d StringDefinition) Inspect(v interface{}) string {
@@ -24,8 +25,18 @@ func (d StringDefinition) DefaultValue() interface{} {
 	return ""
 }
 func (d StringDefinition) Accept(v interface{}) error {
+	if d.AcceptContextFn != nil {
+		return d.AcceptContextFn(context.Background(), v.(string))
+	}
 	if d.AcceptFn != nil {
 		return d.AcceptFn(v.(string))
 	}
 	return nil
 }
+
+func (d StringDefinition) AcceptContext(ctx context.Context, v interface{}) error {
+	if d.AcceptContextFn != nil {
+		return d.AcceptContextFn(ctx, v.(string))
+	}
+	return acceptWithin(ctx, d.Accept, v)
+}
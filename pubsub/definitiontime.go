@@ -0,0 +1,100 @@
+package pubsub
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lenientTimeLayouts are tried after RFC 3339 in lenient mode, times without a zone are UTC
+var lenientTimeLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// TimeDefinition is a point in time. It can be published as a time.Time, an RFC 3339 string
+// or a number of seconds since the unix epoch, and is inspected as RFC 3339 in UTC
+type TimeDefinition struct {
+	Coercion        Coercion
+	AcceptFn        func(v time.Time) error
+	AcceptContextFn func(ctx context.Context, v time.Time) error
+}
+
+func unixTime(f float64, v interface{}, c Coercion) (time.Time, error) {
+	sec, frac := math.Modf(f)
+	if (c == CoerceStrict && frac != 0) || math.IsNaN(f) || sec < math.MinInt64 || sec >= math.MaxInt64 {
+		return time.Time{}, ErrLossyConversion{Value: v, Expected: reflect.Struct}
+	}
+	return time.Unix(int64(sec), int64(math.Round(frac*1e9))).UTC(), nil
+}
+
+func (d TimeDefinition) ValidateAndTransform(v interface{}) (interface{}, error) {
+	switch i := v.(type) {
+	case time.Time:
+		return i, nil
+	case string:
+		s := i
+		if d.Coercion != CoerceStrict {
+			s = strings.TrimSpace(s)
+		}
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err == nil || d.Coercion == CoerceStrict {
+			return t, err
+		}
+		for _, layout := range lenientTimeLayouts {
+			if t, lerr := time.Parse(layout, s); lerr == nil {
+				return t, nil
+			}
+		}
+		if f, ferr := strconv.ParseFloat(s, 64); ferr == nil {
+			return unixTime(f, v, d.Coercion)
+		}
+		return nil, err
+	}
+	rv := reflect.ValueOf(v)
+	switch kindOf(v) {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return time.Unix(rv.Int(), 0).UTC(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if rv.Uint() > math.MaxInt64 {
+			return nil, ErrLossyConversion{Value: v, Expected: reflect.Struct}
+		}
+		return time.Unix(int64(rv.Uint()), 0).UTC(), nil
+	case reflect.Float32, reflect.Float64:
+		return unixTime(rv.Float(), v, d.Coercion)
+	}
+	return nil, ErrInvalidType{Expected: reflect.Struct, Actual: kindOf(v)}
+}
+
+func (d TimeDefinition) Inspect(v interface{}) string {
+	if t, ok := v.(time.Time); ok {
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+	return ""
+}
+
+func (d TimeDefinition) DefaultValue() interface{} {
+	return time.Time{}
+}
+
+func (d TimeDefinition) Accept(v interface{}) error {
+	if d.AcceptContextFn != nil {
+		return d.AcceptContextFn(context.Background(), v.(time.Time))
+	}
+	if d.AcceptFn != nil {
+		return d.AcceptFn(v.(time.Time))
+	}
+	return nil
+}
+
+func (d TimeDefinition) AcceptContext(ctx context.Context, v interface{}) error {
+	if d.AcceptContextFn != nil {
+		return d.AcceptContextFn(ctx, v.(time.Time))
+	}
+	return acceptWithin(ctx, d.Accept, v)
+}
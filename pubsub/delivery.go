@@ -0,0 +1,188 @@
+package pubsub
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// defaultQueueSize is used by asynchronous subscriptions that do not set Delivery.Queue
+const defaultQueueSize = 64
+
+type OverflowPolicy int
+
+const (
+	// OverflowDropOldest discards the oldest queued value to make room, it is the default so a slow
+	// subscription never holds up its publishers
+	OverflowDropOldest OverflowPolicy = iota
+	// OverflowDropNewest discards the value being published
+	OverflowDropNewest
+	// OverflowBlock makes the publisher wait until the queue has room. The value is queued right away and
+	// the publisher waits once the value is out of its hands, a publish made by a subscription worker
+	// never waits so workers cannot wait on each other
+	OverflowBlock
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowDropOldest:
+		return "drop oldest"
+	case OverflowDropNewest:
+		return "drop newest"
+	case OverflowBlock:
+		return "block"
+	}
+	return "unknown"
+}
+
+// Delivery controls how a subscription is called. By default Fn is called synchronously by the publisher,
+// an Async subscription gets its own queue and worker so a slow Fn only holds up its own deliveries.
+// Values of an attribute are always delivered in the order they were recorded
+type Delivery struct {
+	Async    bool
+	Queue    int
+	Overflow OverflowPolicy
+
+	// The pacing options below apply to every matched attribute on its own, so a noisy attribute does not
+	// hold back the others. Only one of them can be set
+
+	// Throttle delivers at most one value per Throttle, a value arriving sooner is held and the latest
+	// held value is delivered once the interval is over
+	Throttle time.Duration
+	// Debounce delivers the latest value once its attribute has been quiet for Debounce
+	Debounce time.Duration
+	// Sample delivers the latest value every Sample, an interval without a new value delivers nothing
+	Sample time.Duration
+}
+
+type delivery struct {
+	ctx       context.Context
+	recCtx    *attributeCtx
+	rec       *ValueRecord
+	response  int
+	publisher string
+	// attempt counts the calls of the subscription with rec, retries go through the queue as well
+	attempt int
+}
+
+type deliveryQueue struct {
+	lock     sync.Mutex
+	cond     *sync.Cond
+	items    []delivery
+	size     int
+	overflow OverflowPolicy
+	dropped  int
+	closed   bool
+}
+
+func newDeliveryQueue(d Delivery) *deliveryQueue {
+	q := &deliveryQueue{
+		size:     d.Queue,
+		overflow: d.Overflow,
+	}
+	if q.size <= 0 {
+		q.size = defaultQueueSize
+	}
+	q.cond = sync.NewCond(&q.lock)
+	return q
+}
+
+// workerKey marks the context of a delivery made by the worker of a subscription
+type workerKey struct{}
+
+// push queues d applying the overflow policy, it returns the queue depth, the total number of values
+// dropped by the queue and the deliveries that were dropped to make room, which may include d itself.
+// A blocking queue takes d even when it is full, see waitRoom
+func (q *deliveryQueue) push(d delivery) (depth int, totalDropped int, dropped []delivery) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed {
+		return 0, q.dropped, []delivery{d}
+	}
+	for len(q.items) >= q.size && q.overflow != OverflowBlock {
+		if q.overflow == OverflowDropNewest {
+			q.dropped++
+			return len(q.items), q.dropped, append(dropped, d)
+		}
+		dropped = append(dropped, q.items[0])
+		q.items = q.items[1:]
+		q.dropped++
+	}
+	q.items = append(q.items, d)
+	q.cond.Broadcast()
+	return len(q.items), q.dropped, dropped
+}
+
+// waitRoom blocks while a blocking queue holds more than its size. It is called by the publisher once
+// its fanout is over, waiting inside of it would hold up the worker publishing to the same attribute
+func (q *deliveryQueue) waitRoom() {
+	if q.overflow != OverflowBlock {
+		return
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for !q.closed && len(q.items) > q.size {
+		q.cond.Wait()
+	}
+}
+
+func (q *deliveryQueue) pop() (delivery, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for !q.closed && len(q.items) == 0 {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return delivery{}, false
+	}
+	d := q.items[0]
+	q.items[0] = delivery{}
+	q.items = q.items[1:]
+	q.cond.Broadcast()
+	return d, true
+}
+
+// close stops the queue and returns the deliveries that never made it to the worker
+func (q *deliveryQueue) close() []delivery {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	pending := q.items
+	q.items = nil
+	q.cond.Broadcast()
+	return pending
+}
+
+func markDropped(ds []delivery) {
+	for _, d := range ds {
+		d.recCtx.updateResponse(d.rec, d.response, func(res *SubscriptionResponse) {
+			res.Pending = false
+			res.Retrying = false
+			res.Dropped = true
+		})
+	}
+}
+
+func (ctx *Broker) worker(id string, sub *subscriptionCtx) {
+	for {
+		d, ok := sub.queue.pop()
+		if !ok {
+			return
+		}
+		if !sub.active() {
+			markDropped([]delivery{d})
+			continue
+		}
+		errs := ctx.call(context.WithValue(d.ctx, workerKey{}, sub), id, sub, d.publisher, d.recCtx, d.rec)
+		d.recCtx.updateResponse(d.rec, d.response, func(res *SubscriptionResponse) {
+			res.Pending = false
+			res.Attempts = d.attempt
+			res.Retrying = sub.retrying(d.attempt, errs)
+			res.Err = append(res.Err, errs...)
+		})
+		ctx.retry(d.ctx, id, sub, d.publisher, d.recCtx, d.rec, d.response, d.attempt, errs)
+	}
+}
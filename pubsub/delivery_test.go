@@ -0,0 +1,210 @@
+package pubsub
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func asyncBroker(t *testing.T, delivery Delivery, fn func(ctx Context, v Value)) (*Broker, BasicNode) {
+	n1 := BasicNode{
+		ID: "n1",
+		Attributes: []Attribute{
+			{Name: "a1", Definition: DoubleDefinition{}},
+			{Name: "a2", Definition: DoubleDefinition{}},
+		},
+	}
+	broker := &Broker{}
+	if err := broker.Register(n1); err != nil {
+		t.Fatal(err)
+	}
+	err := broker.Subscribe(BasicNode{ID: "sub"}, Subscription{
+		Name:     "async",
+		Filter:   "n1.>",
+		Fn:       fn,
+		Delivery: delivery,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return broker, n1
+}
+
+func TestDeliveryAsyncDoesNotBlockPublisher(t *testing.T) {
+	release := make(chan struct{})
+	broker, n1 := asyncBroker(t, Delivery{Async: true, Queue: 10}, func(ctx Context, v Value) {
+		<-release
+	})
+	defer close(release)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 5; i++ {
+			broker.Publish(n1, "n1.a1", i)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publisher blocked by a slow asynchronous subscription")
+	}
+
+	rec, err := broker.Value("n1.a1", time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.SubscriptionResponses) != 1 || !rec.SubscriptionResponses[0].Pending {
+		t.Errorf("expected a pending response got %+v", rec.SubscriptionResponses)
+	}
+	if depth := rec.SubscriptionResponses[0].QueueDepth; depth < 1 {
+		t.Errorf("expected a queue depth got %d", depth)
+	}
+}
+
+func TestDeliveryOrdering(t *testing.T) {
+	const publishes = 200
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	last := map[string]float64{"n1.a1": -1, "n1.a2": -1}
+	var outOfOrder []string
+	broker, n1 := asyncBroker(t, Delivery{Async: true, Queue: 4, Overflow: OverflowBlock}, func(ctx Context, v Value) {
+		lock.Lock()
+		defer lock.Unlock()
+		if v.Value.(float64) <= last[v.AttributeID] && v.Value.(float64) != 0 {
+			outOfOrder = append(outOfOrder, fmt.Sprintf("%s %v after %v", v.AttributeID, v.Value, last[v.AttributeID]))
+		}
+		last[v.AttributeID] = v.Value.(float64)
+		if v.Value.(float64) == publishes {
+			wg.Done()
+		}
+	})
+
+	wg.Add(2)
+	for _, attr := range []string{"n1.a1", "n1.a2"} {
+		go func(attr string) {
+			for i := 1; i <= publishes; i++ {
+				if err := broker.Publish(n1, attr, i); err != nil {
+					t.Error(err)
+				}
+			}
+		}(attr)
+	}
+	wg.Wait()
+	if len(outOfOrder) > 0 {
+		t.Errorf("values delivered out of order: %v", outOfOrder)
+	}
+}
+
+func TestDeliveryOverflow(t *testing.T) {
+	for _, policy := range []OverflowPolicy{OverflowDropOldest, OverflowDropNewest} {
+		t.Run(policy.String(), func(t *testing.T) {
+			release := make(chan struct{})
+			started := make(chan struct{}, 1)
+			var lock sync.Mutex
+			var delivered []float64
+			broker, n1 := asyncBroker(t, Delivery{Async: true, Queue: 2, Overflow: policy}, func(ctx Context, v Value) {
+				started <- struct{}{}
+				<-release
+				lock.Lock()
+				delivered = append(delivered, v.Value.(float64))
+				lock.Unlock()
+			})
+			// the worker is stuck delivering a2, so a1 1..5 all land in the queue of 2
+			broker.Publish(n1, "n1.a2", 100)
+			<-started
+			for i := 1; i <= 5; i++ {
+				broker.Publish(n1, "n1.a1", i)
+			}
+			rec, _ := broker.Value("n1.a1", time.Now().Add(time.Second))
+			if total := rec.SubscriptionResponses[0].TotalDropped; total == 0 {
+				t.Errorf("expected drops to be reported got %d", total)
+			}
+			close(release)
+			for i := 0; i < 2; i++ {
+				select {
+				case <-started:
+				case <-time.After(5 * time.Second):
+					t.Fatal("timeout waiting for delivery")
+				}
+			}
+
+			var expected []float64
+			switch policy {
+			case OverflowDropOldest:
+				expected = []float64{100, 4, 5}
+			case OverflowDropNewest:
+				expected = []float64{100, 1, 2}
+			}
+			time.Sleep(10 * time.Millisecond)
+			lock.Lock()
+			defer lock.Unlock()
+			if fmt.Sprint(delivered) != fmt.Sprint(expected) {
+				t.Errorf("expected %v got %v", expected, delivered)
+			}
+			dropped := 0
+			recCtx, _ := broker.attribute("n1.a1")
+			recCtx.lock.RLock()
+			for _, rec := range recCtx.Records {
+				for _, res := range rec.SubscriptionResponses {
+					if res.Dropped {
+						dropped++
+					}
+				}
+			}
+			recCtx.lock.RUnlock()
+			if dropped != 3 {
+				t.Errorf("expected 3 dropped records got %d", dropped)
+			}
+		})
+	}
+}
+
+func TestDeliveryOverflowBlockFromWorker(t *testing.T) {
+	broker, n1 := asyncBroker(t, Delivery{Async: true, Queue: 1, Overflow: OverflowBlock}, func(ctx Context, v Value) {
+		// the second publish finds the queue full, the worker must not wait for itself
+		if v.Value.(float64) < 3 {
+			ctx.Publish("n1.a1", v.Value.(float64)+1)
+			ctx.Publish("n1.a1", v.Value.(float64)+1)
+		}
+	})
+
+	done := make(chan struct{})
+	go func() {
+		broker.Publish(n1, "n1.a1", 0)
+		time.Sleep(10 * time.Millisecond)
+		broker.Publish(n1, "n1.a1", 100)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publisher blocked by a subscription publishing into its own full queue")
+	}
+}
+
+func TestDeliveryOverflowBlockWorkerPublishesInTurn(t *testing.T) {
+	// the publisher below blocks on the full queue while the worker publishes to the same attribute,
+	// whose record is next in line behind the blocked one
+	broker, n1 := asyncBroker(t, Delivery{Async: true, Queue: 1, Overflow: OverflowBlock}, func(ctx Context, v Value) {
+		if v.AttributeID == "n1.a2" && v.Value.(float64) < 1000 {
+			// long enough for the queue to fill up and the publisher to block
+			time.Sleep(time.Millisecond)
+			ctx.Publish("n1.a2", v.Value.(float64)+1000)
+		}
+	})
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 50; i++ {
+			broker.Publish(n1, "n1.a2", i)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publisher and worker deadlocked on the turn of the attribute")
+	}
+}
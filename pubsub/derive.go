@@ -0,0 +1,153 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// Derivation makes an attribute computed from other attributes, the broker recomputes it whenever
+// one of its inputs records a value. Each record of the attribute is caused by the input record
+// that triggered it and lists the records of every input in Value.Inputs
+type Derivation struct {
+	// Inputs are filters in ParseFilter syntax selecting the attributes the value is computed from
+	Inputs []string
+	// Fn computes the value from the latest value of every input keyed by attribute id,
+	// returning a nil value leaves the attribute as it is
+	Fn func(inputs map[string]Value) (interface{}, error)
+}
+
+func (d Derivation) isZero() bool {
+	return d.Fn == nil
+}
+
+func (d Derivation) validate() error {
+	if d.Fn == nil && len(d.Inputs) > 0 {
+		return fmt.Errorf("derivation with inputs needs a fn")
+	}
+	if d.Fn != nil && len(d.Inputs) == 0 {
+		return fmt.Errorf("derivation needs at least one input")
+	}
+	for _, filter := range d.Inputs {
+		if _, err := ParseFilter(filter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// latest returns the newest record, lock free callers only
+func (ctx *attributeCtx) latest() (ValueRecord, bool) {
+	ctx.lock.RLock()
+	defer ctx.lock.RUnlock()
+	if len(ctx.Records) == 0 {
+		return ValueRecord{}, false
+	}
+	return copyRecord(ctx.Records[len(ctx.Records)-1]), true
+}
+
+// dependents returns the derived attributes with an input matching attr, lock held
+func (ctx *Broker) dependents(attr string) []*attributeCtx {
+	var derived []*attributeCtx
+	for _, id := range ctx.derivedIndex.matchFilters(attr) {
+		if recCtx, ok := ctx.attributes[id]; ok && id != attr {
+			derived = append(derived, recCtx)
+		}
+	}
+	return derived
+}
+
+// derive recomputes the attributes derived from the attribute of rec
+func (ctx *Broker) derive(c context.Context, rec *ValueRecord) {
+	ctx.lock.RLock()
+	derived := ctx.dependents(rec.AttributeID)
+	ctx.lock.RUnlock()
+	for _, recCtx := range derived {
+		// the input is recorded already so the derived value must not be lost to the deadline of the publish
+		ctx.recompute(detachedContext{c}, recCtx, rec)
+	}
+}
+
+type pendingDerive struct {
+	c       context.Context
+	trigger *ValueRecord
+}
+
+// recompute publishes a new value for the derived attribute, trigger is the input record that caused it if any.
+// Recomputations of an attribute run one at a time, one asked for while another runs is coalesced into a
+// single one following it, so the last value published is always computed from the latest inputs
+func (ctx *Broker) recompute(c context.Context, recCtx *attributeCtx, trigger *ValueRecord) {
+	if c == nil {
+		c = context.Background()
+	}
+	recCtx.deriveLock.Lock()
+	if recCtx.deriving {
+		// this also catches a recompute caused by the publish of the running one
+		recCtx.redrive = &pendingDerive{c: c, trigger: trigger}
+		recCtx.deriveLock.Unlock()
+		return
+	}
+	recCtx.deriving = true
+	for {
+		recCtx.deriveLock.Unlock()
+		ctx.compute(c, recCtx, trigger)
+		recCtx.deriveLock.Lock()
+		if recCtx.redrive == nil {
+			recCtx.deriving = false
+			recCtx.deriveLock.Unlock()
+			return
+		}
+		c, trigger = recCtx.redrive.c, recCtx.redrive.trigger
+		recCtx.redrive = nil
+	}
+}
+
+// compute reads the inputs of the derived attribute and publishes the value computed from them
+func (ctx *Broker) compute(c context.Context, recCtx *attributeCtx, trigger *ValueRecord) {
+	ctx.lock.RLock()
+	var inputCtxs []*attributeCtx
+	seen := make(map[string]bool)
+	for _, filter := range recCtx.Attribute.Derive.Inputs {
+		for _, id := range ctx.attributeIndex.matchKeys(filter) {
+			if !seen[id] && id != recCtx.id {
+				seen[id] = true
+				inputCtxs = append(inputCtxs, ctx.attributes[id])
+			}
+		}
+	}
+	ctx.lock.RUnlock()
+
+	inputs := make(map[string]Value, len(inputCtxs))
+	var refs []RecordRef
+	for _, input := range inputCtxs {
+		if rec, ok := input.latest(); ok {
+			inputs[input.id] = rec.Value
+			refs = append(refs, rec.Ref())
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].AttributeID < refs[j].AttributeID
+	})
+
+	v, err := recCtx.Attribute.Derive.Fn(inputs)
+	if err != nil {
+		ctx.log().Printf("error derive attribute:'%s' err: %s", recCtx.id, err)
+		return
+	}
+	if v == nil {
+		return
+	}
+	// derived values are published by the owner of the attribute, the error is logged by publish
+	ctx.publish(c, recCtx.node, recCtx.id, v, trigger, refs)
+}
+
+// rederive recomputes the derived attributes after their inputs changed without a publish
+func (ctx *Broker) rederive(derived []*attributeCtx) {
+	done := make(map[*attributeCtx]bool, len(derived))
+	for _, recCtx := range derived {
+		if !done[recCtx] {
+			done[recCtx] = true
+			ctx.recompute(context.Background(), recCtx, nil)
+		}
+	}
+}
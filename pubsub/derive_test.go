@@ -0,0 +1,217 @@
+package pubsub
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDerivedAttributes(t *testing.T) {
+	broker := &Broker{}
+	bedroom := func(id string) BasicNode {
+		return BasicNode{ID: id, Attributes: []Attribute{
+			{Name: "temp", Definition: DoubleDefinition{}},
+			{Name: "window", Definition: BooleanDefinition{}},
+		}}
+	}
+	home := BasicNode{ID: "home", Attributes: []Attribute{
+		{Name: "temp", Definition: DoubleDefinition{}, Derive: Derivation{
+			Inputs: []string{"*.temp"},
+			Fn: func(inputs map[string]Value) (interface{}, error) {
+				if len(inputs) == 0 {
+					return nil, nil
+				}
+				var sum float64
+				for _, v := range inputs {
+					sum += v.Value.(float64)
+				}
+				return sum / float64(len(inputs)), nil
+			},
+		}},
+		{Name: "window_open", Definition: BooleanDefinition{}, Derive: Derivation{
+			Inputs: []string{"*.window"},
+			Fn: func(inputs map[string]Value) (interface{}, error) {
+				for _, v := range inputs {
+					if v.Value.(bool) {
+						return true, nil
+					}
+				}
+				return false, nil
+			},
+		}},
+	}}
+
+	b1, b2 := bedroom("bedroom_1"), bedroom("bedroom_2")
+	if err := broker.Register(home); err != nil {
+		t.Fatal(err)
+	}
+	if rec, _ := broker.Value("home.temp", time.Now()); rec.Value.Value != 0.0 || len(rec.Inputs) != 0 {
+		t.Errorf("expected the default without inputs got %+v", rec)
+	}
+	for _, n := range []BasicNode{b1, b2} {
+		if err := broker.Register(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := broker.Publish(b1, "bedroom_1.temp", 20); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.Publish(b2, "bedroom_2.temp", 23); err != nil {
+		t.Fatal(err)
+	}
+	trigger, err := broker.Value("bedroom_2.temp", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := broker.Value("home.temp", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Value.Value != 21.5 {
+		t.Errorf("expected the average 21.5 got %v", rec.Value.Value)
+	}
+	if rec.CausedBy != trigger.Ref() || rec.UpdatedBy != "home" || rec.Hops != 1 {
+		t.Errorf("expected the record to be caused by %s got %+v", trigger.Ref(), rec.Value)
+	}
+	if len(rec.Inputs) != 2 || rec.Inputs[0].AttributeID != "bedroom_1.temp" || rec.Inputs[1] != trigger.Ref() {
+		t.Errorf("expected both inputs to be recorded got %v", rec.Inputs)
+	}
+
+	if err := broker.Publish(b2, "bedroom_2.window", "open"); err == nil {
+		t.Error("expected an invalid boolean to be rejected")
+	}
+	if err := broker.Publish(b2, "bedroom_2.window", true); err != nil {
+		t.Fatal(err)
+	}
+	if rec, _ := broker.Value("home.window_open", time.Now()); rec.Value.Value != true {
+		t.Errorf("expected a window to be open got %v", rec.Value.Value)
+	}
+
+	// removing an input recomputes without it
+	if err := broker.Unregister(b2); err != nil {
+		t.Fatal(err)
+	}
+	if rec, _ := broker.Value("home.temp", time.Now()); rec.Value.Value != 20.0 || rec.CausedBy.IsZero() == false {
+		t.Errorf("expected the average of the remaining input got %+v", rec.Value)
+	}
+	if rec, _ := broker.Value("home.window_open", time.Now()); rec.Value.Value != false {
+		t.Errorf("expected no window to be open got %v", rec.Value.Value)
+	}
+}
+
+func TestDerivedChain(t *testing.T) {
+	broker := &Broker{MaxHops: 3}
+	meter := BasicNode{ID: "meter", Attributes: []Attribute{
+		{Name: "voltage", Definition: DoubleDefinition{}},
+		{Name: "current", Definition: DoubleDefinition{}},
+		{Name: "power", Definition: DoubleDefinition{Unit: Watt}, Derive: Derivation{
+			Inputs: []string{"meter.voltage", "meter.current"},
+			Fn: func(inputs map[string]Value) (interface{}, error) {
+				v, vok := inputs["meter.voltage"]
+				i, iok := inputs["meter.current"]
+				if !vok || !iok {
+					return nil, nil
+				}
+				return v.Value.(float64) * i.Value.(float64), nil
+			},
+		}},
+		{Name: "power_kw", Definition: DoubleDefinition{Unit: Kilowatt}, Derive: Derivation{
+			Inputs: []string{"meter.power"},
+			Fn: func(inputs map[string]Value) (interface{}, error) {
+				w, ok := inputs["meter.power"]
+				if !ok {
+					return nil, nil
+				}
+				if w.Value.(float64) < 0 {
+					return nil, errors.New("negative power")
+				}
+				return Quantity{Value: w.Value.(float64), Unit: w.Unit}, nil
+			},
+		}},
+	}}
+	if err := broker.Register(meter); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.Publish(meter, "meter.voltage", 230); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.Publish(meter, "meter.current", 10); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := broker.Value("meter.power_kw", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Value.Value != 2.3 || rec.Hops != 2 {
+		t.Errorf("expected 2.3 kW two hops from the input got %v hops %d", rec.Value.Value, rec.Hops)
+	}
+	chain, err := broker.CausalChain(rec.Ref())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 3 || chain[len(chain)-1].AttributeID != "meter.current" {
+		var ids []string
+		for _, c := range chain {
+			ids = append(ids, c.AttributeID)
+		}
+		t.Errorf("expected the chain to lead back to the current got %v", ids)
+	}
+
+	// an error from Fn leaves the attribute as it is
+	if err := broker.Publish(meter, "meter.current", -1); err != nil {
+		t.Fatal(err)
+	}
+	if rec, _ := broker.Value("meter.power_kw", time.Now()); rec.Value.Value != 2.3 {
+		t.Errorf("expected the failed derivation to keep 2.3 got %v", rec.Value.Value)
+	}
+
+	invalid := BasicNode{ID: "invalid", Attributes: []Attribute{
+		{Name: "x", Definition: DoubleDefinition{}, Derive: Derivation{Fn: func(map[string]Value) (interface{}, error) { return 1, nil }}},
+	}}
+	if err := broker.Register(invalid); err == nil {
+		t.Error("expected a derivation without inputs to be rejected")
+	}
+}
+
+func TestDerivedConcurrentInputs(t *testing.T) {
+	broker := &Broker{}
+	n := BasicNode{ID: "n", Attributes: []Attribute{
+		{Name: "x", Definition: IntegerDefinition{}},
+		{Name: "y", Definition: IntegerDefinition{}},
+		{Name: "sum", Definition: IntegerDefinition{}, Derive: Derivation{
+			Inputs: []string{"n.x", "n.y"},
+			Fn: func(inputs map[string]Value) (interface{}, error) {
+				var sum int64
+				for _, v := range inputs {
+					sum += v.Value.(int64)
+				}
+				// widen the window between reading the inputs and publishing the sum
+				time.Sleep(100 * time.Microsecond)
+				return sum, nil
+			},
+		}},
+	}}
+	if err := broker.Register(n); err != nil {
+		t.Fatal(err)
+	}
+	for round := 0; round < 50; round++ {
+		var wg sync.WaitGroup
+		for _, attr := range []string{"n.x", "n.y"} {
+			wg.Add(1)
+			go func(attr string) {
+				defer wg.Done()
+				for i := 0; i < 5; i++ {
+					broker.Publish(n, attr, round*10+i)
+				}
+			}(attr)
+		}
+		wg.Wait()
+		x, _ := broker.Value("n.x", time.Now())
+		y, _ := broker.Value("n.y", time.Now())
+		sum, _ := broker.Value("n.sum", time.Now())
+		if sum.Value.Value != x.Value.Value.(int64)+y.Value.Value.(int64) {
+			t.Fatalf("round %d expected %v+%v got %v", round, x.Value.Value, y.Value.Value, sum.Value.Value)
+		}
+	}
+}
@@ -15,6 +15,17 @@ func (e ErrNoValue) Error() string {
 	return fmt.Sprintf("no value for attribute: '%s' at: %s", e.Attribute, e.Timestamp.Local().Format(time.RubyDate))
 }
 
+// ErrNotRetained is returned for a query that falls before the history kept by the attribute's RetentionPolicy
+type ErrNotRetained struct {
+	Attribute    string
+	Timestamp    time.Time
+	RetainedFrom time.Time
+}
+
+func (e ErrNotRetained) Error() string {
+	return fmt.Sprintf("no value for attribute: '%s' at: %s, history is retained from: %s", e.Attribute, e.Timestamp.Local().Format(time.RubyDate), e.RetainedFrom.Local().Format(time.RubyDate))
+}
+
 type ErrDuplicateAttribute struct {
 	Attribute string
 }
@@ -23,6 +34,50 @@ func (e ErrDuplicateAttribute) Error() string {
 	return fmt.Sprintf("duplicate attribute '%s'", e.Attribute)
 }
 
+type ErrDuplicateSubscription struct {
+	Subscription string
+}
+
+func (e ErrDuplicateSubscription) Error() string {
+	return fmt.Sprintf("duplicate subscription '%s'", e.Subscription)
+}
+
+// ErrInvalidName is returned for a node id, attribute name or subscription name that does not follow the grammar
+type ErrInvalidName struct {
+	Name   string
+	Reason string
+}
+
+func (e ErrInvalidName) Error() string {
+	return fmt.Sprintf("invalid name '%s': %s", e.Name, e.Reason)
+}
+
+type ErrDuplicateFunction struct {
+	Function string
+}
+
+func (e ErrDuplicateFunction) Error() string {
+	return fmt.Sprintf("duplicate function '%s'", e.Function)
+}
+
+type ErrUnknownFunction struct {
+	Function string
+}
+
+func (e ErrUnknownFunction) Error() string {
+	return fmt.Sprintf("unknown function '%s'", e.Function)
+}
+
+// ErrInvalidFilter is returned for a filter that does not follow the syntax of ParseFilter
+type ErrInvalidFilter struct {
+	Filter string
+	Reason string
+}
+
+func (e ErrInvalidFilter) Error() string {
+	return fmt.Sprintf("invalid filter '%s': %s", e.Filter, e.Reason)
+}
+
 type ErrUnknownAttribute struct {
 	Attribute string
 }
@@ -31,6 +86,14 @@ func (e ErrUnknownAttribute) Error() string {
 	return fmt.Sprintf("unknown attribute '%s'", e.Attribute)
 }
 
+type ErrUnknownRecord struct {
+	Record RecordRef
+}
+
+func (e ErrUnknownRecord) Error() string {
+	return fmt.Sprintf("unknown record '%s'", e.Record)
+}
+
 type ErrInvalidType struct {
 	Expected reflect.Kind
 	Actual   reflect.Kind
@@ -39,3 +102,221 @@ type ErrInvalidType struct {
 func (e ErrInvalidType) Error() string {
 	return fmt.Sprintf("invalid type expected '%s' but got '%s'", e.Expected, e.Actual)
 }
+
+type ErrUnknownSubscription struct {
+	Subscription string
+}
+
+func (e ErrUnknownSubscription) Error() string {
+	return fmt.Sprintf("unknown subscription '%s'", e.Subscription)
+}
+
+// ErrMaxHops is returned when a chain of subscription triggered publishes grows longer than Broker.MaxHops,
+// usually because subscriptions are feeding each other in a loop
+type ErrMaxHops struct {
+	Attribute   string
+	Hops        int
+	CausedBy    RecordRef
+	Correlation RecordRef
+}
+
+func (e ErrMaxHops) Error() string {
+	return fmt.Sprintf("max hops exceeded publishing attribute '%s' hops: %d caused by: %s started by: %s", e.Attribute, e.Hops, e.CausedBy, e.Correlation)
+}
+
+// ErrPublishTimeout is returned when the context of a publish is done before the value was accepted
+type ErrPublishTimeout struct {
+	Attribute string
+	Err       error
+}
+
+func (e ErrPublishTimeout) Error() string {
+	return fmt.Sprintf("publish attribute '%s': %s", e.Attribute, e.Err)
+}
+
+func (e ErrPublishTimeout) Unwrap() error {
+	return e.Err
+}
+
+// ErrCallTimeout is returned when the context of a call is done before the function returned
+type ErrCallTimeout struct {
+	Function string
+	Err      error
+}
+
+func (e ErrCallTimeout) Error() string {
+	return fmt.Sprintf("call function '%s': %s", e.Function, e.Err)
+}
+
+func (e ErrCallTimeout) Unwrap() error {
+	return e.Err
+}
+
+// ErrSubscriptionTimeout is reported on the SubscriptionResponse of a subscription that ran past its Timeout
+type ErrSubscriptionTimeout struct {
+	Subscription string
+	Attribute    string
+	Timeout      time.Duration
+}
+
+func (e ErrSubscriptionTimeout) Error() string {
+	return fmt.Sprintf("subscription '%s' timed out after %s handling attribute '%s'", e.Subscription, e.Timeout, e.Attribute)
+}
+
+// ErrInvalidEnumValue is returned for a value that is neither one of the labels of an EnumDefinition nor a valid index
+type ErrInvalidEnumValue struct {
+	Value   interface{}
+	Options []string
+}
+
+func (e ErrInvalidEnumValue) Error() string {
+	return fmt.Sprintf("invalid enum value '%v' expected one of %v", e.Value, e.Options)
+}
+
+// ErrOutOfRange is returned by a numeric definition rejecting a value outside of its Min and Max
+type ErrOutOfRange struct {
+	Value float64
+	Min   *float64
+	Max   *float64
+}
+
+func (e ErrOutOfRange) Error() string {
+	min, max := "-inf", "+inf"
+	if e.Min != nil {
+		min = fmt.Sprint(*e.Min)
+	}
+	if e.Max != nil {
+		max = fmt.Sprint(*e.Max)
+	}
+	return fmt.Sprintf("value %v out of range [%s, %s]", e.Value, min, max)
+}
+
+// ErrOutOfStep is returned by a numeric definition rejecting a value that is not a multiple of its Step
+type ErrOutOfStep struct {
+	Value float64
+	Step  float64
+}
+
+func (e ErrOutOfStep) Error() string {
+	return fmt.Sprintf("value %v is not a multiple of step %v", e.Value, e.Step)
+}
+
+type ErrIncompatibleUnit struct {
+	From Unit
+	To   Unit
+}
+
+func (e ErrIncompatibleUnit) Error() string {
+	from, to := e.From.Symbol, e.To.Symbol
+	if from == "" {
+		from = "no unit"
+	}
+	if to == "" {
+		to = "no unit"
+	}
+	return fmt.Sprintf("cannot convert '%s' to '%s'", from, to)
+}
+
+type ErrUnknownUnit struct {
+	Symbol string
+}
+
+func (e ErrUnknownUnit) Error() string {
+	return fmt.Sprintf("unknown unit '%s'", e.Symbol)
+}
+
+// ErrInvalidField wraps the error of a single field of an ObjectDefinition
+type ErrInvalidField struct {
+	Field string
+	Err   error
+}
+
+func (e ErrInvalidField) Error() string {
+	return fmt.Sprintf("invalid field '%s': %s", e.Field, e.Err)
+}
+
+func (e ErrInvalidField) Unwrap() error {
+	return e.Err
+}
+
+type ErrMissingField struct {
+	Field string
+}
+
+func (e ErrMissingField) Error() string {
+	return fmt.Sprintf("missing required field '%s'", e.Field)
+}
+
+type ErrUnknownField struct {
+	Field string
+}
+
+func (e ErrUnknownField) Error() string {
+	return fmt.Sprintf("unknown field '%s'", e.Field)
+}
+
+// ErrInvalidElement wraps the error of a single element of an ArrayDefinition
+type ErrInvalidElement struct {
+	Index int
+	Err   error
+}
+
+func (e ErrInvalidElement) Error() string {
+	return fmt.Sprintf("invalid element %d: %s", e.Index, e.Err)
+}
+
+func (e ErrInvalidElement) Unwrap() error {
+	return e.Err
+}
+
+type ErrInvalidLength struct {
+	Length    int
+	MinLength int
+	MaxLength int
+}
+
+func (e ErrInvalidLength) Error() string {
+	if e.MaxLength > 0 {
+		return fmt.Sprintf("invalid length %d expected between %d and %d", e.Length, e.MinLength, e.MaxLength)
+	}
+	return fmt.Sprintf("invalid length %d expected at least %d", e.Length, e.MinLength)
+}
+
+type ErrUnknownSchemaType struct {
+	Type string
+}
+
+func (e ErrUnknownSchemaType) Error() string {
+	return fmt.Sprintf("unknown schema type '%s'", e.Type)
+}
+
+// ErrReadOnly is returned when a node publishes to a read only attribute it does not own
+type ErrReadOnly struct {
+	Attribute string
+	Publisher string
+}
+
+func (e ErrReadOnly) Error() string {
+	return fmt.Sprintf("attribute '%s' is read only for '%s'", e.Attribute, e.Publisher)
+}
+
+// ErrSuppressed is returned when the ChangeFilter of an attribute suppressed a publish of its owner
+type ErrSuppressed struct {
+	Attribute string
+	Reason    SuppressReason
+}
+
+func (e ErrSuppressed) Error() string {
+	return fmt.Sprintf("attribute '%s' suppressed the update, reason '%s'", e.Attribute, e.Reason)
+}
+
+// ErrLossyConversion is returned for a value that cannot become the type of a definition without losing
+// information, such as 3.7 for an integer or a number past the range of the type
+type ErrLossyConversion struct {
+	Value    interface{}
+	Expected reflect.Kind
+}
+
+func (e ErrLossyConversion) Error() string {
+	return fmt.Sprintf("cannot convert '%v' to '%s' without losing information", e.Value, e.Expected)
+}
@@ -0,0 +1,272 @@
+package pubsub
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type segmentKind int
+
+const (
+	segmentLiteral segmentKind = iota
+	// segmentAny is '*', exactly one segment
+	segmentAny
+	// segmentRest is '>', everything that follows
+	segmentRest
+	// segmentDeep is '**', zero or more segments
+	segmentDeep
+	// segmentGlob is a segment with '*', '?' or '{a,b}' in it
+	segmentGlob
+)
+
+type segmentPattern struct {
+	kind segmentKind
+	text string
+	// alternatives of a glob with its braces expanded
+	alternatives []string
+}
+
+// Filter is a parsed filter, see ParseFilter for the syntax
+type Filter struct {
+	raw     string
+	include []segmentPattern
+	except  [][]segmentPattern
+}
+
+// ParseFilter parses a filter. A filter is made of segments separated by '.' and matches a key segment by segment:
+//
+//	gpio        matches the segment 'gpio'
+//	*           matches any one segment
+//	>           matches whatever follows, any segments after it are ignored
+//	**          matches zero or more segments
+//	sensor-*    matches a segment starting with 'sensor-', '*' is any run of characters and '?' a single one
+//	{gpio,adc}  matches either 'gpio' or 'adc', alternatives can hold globs but not '.' or braces
+//
+// A key that is shorter than the filter is matched as if it went on with empty segments, which only '*', '>',
+// '**' and globs matching an empty string match, so 'n1.*' also matches 'n1'. A key that is longer only matches
+// when the filter ends in '>' or '**'.
+// The filter can be followed by exclusions, 'pattern except pattern except pattern', a key matches when
+// it matches the first pattern and none of the others
+func ParseFilter(filter string) (Filter, error) {
+	f := Filter{raw: filter}
+	fields := strings.Fields(filter)
+	if len(fields) == 0 {
+		// the empty filter only matches the empty key
+		f.include = []segmentPattern{{kind: segmentLiteral}}
+		return f, nil
+	}
+	if len(fields)%2 == 0 {
+		return f, ErrInvalidFilter{Filter: filter, Reason: "expected 'pattern except pattern'"}
+	}
+	for i, field := range fields {
+		if i%2 == 1 {
+			if field != "except" {
+				return f, ErrInvalidFilter{Filter: filter, Reason: fmt.Sprintf("unexpected '%s', expected 'except'", field)}
+			}
+			continue
+		}
+		segs, err := parsePattern(filter, field)
+		if err != nil {
+			return f, err
+		}
+		if i == 0 {
+			f.include = segs
+		} else {
+			f.except = append(f.except, segs)
+		}
+	}
+	return f, nil
+}
+
+func parsePattern(filter, pattern string) ([]segmentPattern, error) {
+	var segs []segmentPattern
+	for _, seg := range strings.Split(pattern, ".") {
+		p, err := parseSegment(filter, seg)
+		if err != nil {
+			return nil, err
+		}
+		segs = append(segs, p)
+	}
+	return segs, nil
+}
+
+func parseSegment(filter, seg string) (segmentPattern, error) {
+	switch seg {
+	case "*":
+		return segmentPattern{kind: segmentAny, text: seg}, nil
+	case ">":
+		return segmentPattern{kind: segmentRest, text: seg}, nil
+	case "**":
+		return segmentPattern{kind: segmentDeep, text: seg}, nil
+	}
+	if strings.Contains(seg, "**") {
+		return segmentPattern{}, ErrInvalidFilter{Filter: filter, Reason: fmt.Sprintf("'**' must be a whole segment in '%s'", seg)}
+	}
+	if strings.ContainsRune(seg, '>') {
+		return segmentPattern{}, ErrInvalidFilter{Filter: filter, Reason: fmt.Sprintf("'>' must be a whole segment in '%s'", seg)}
+	}
+	for _, c := range seg {
+		if !unicode.IsPrint(c) {
+			return segmentPattern{}, ErrInvalidFilter{Filter: filter, Reason: fmt.Sprintf("control character in segment '%s'", seg)}
+		}
+	}
+	if !strings.ContainsAny(seg, "*?{}") {
+		return segmentPattern{kind: segmentLiteral, text: seg}, nil
+	}
+	alternatives, err := expandBraces(filter, seg)
+	if err != nil {
+		return segmentPattern{}, err
+	}
+	return segmentPattern{kind: segmentGlob, text: seg, alternatives: alternatives}, nil
+}
+
+// expandBraces turns 'a{b,c}d' into 'abd' and 'acd', braces cannot be nested
+func expandBraces(filter, seg string) ([]string, error) {
+	open := strings.IndexRune(seg, '{')
+	close := strings.IndexRune(seg, '}')
+	switch {
+	case open < 0 && close < 0:
+		return []string{seg}, nil
+	case open < 0 || close < open:
+		return nil, ErrInvalidFilter{Filter: filter, Reason: fmt.Sprintf("unbalanced '}' in segment '%s'", seg)}
+	case close < 0:
+		return nil, ErrInvalidFilter{Filter: filter, Reason: fmt.Sprintf("unbalanced '{' in segment '%s'", seg)}
+	}
+	inner := seg[open+1 : close]
+	if strings.ContainsRune(inner, '{') {
+		return nil, ErrInvalidFilter{Filter: filter, Reason: fmt.Sprintf("nested '{' in segment '%s'", seg)}
+	}
+	rest, err := expandBraces(filter, seg[close+1:])
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, alt := range strings.Split(inner, ",") {
+		for _, r := range rest {
+			out = append(out, seg[:open]+alt+r)
+		}
+	}
+	return out, nil
+}
+
+// globMatch matches s against a pattern where '*' is any run of characters and '?' a single character
+func globMatch(pattern, s string) bool {
+	p := []rune(pattern)
+	r := []rune(s)
+	// star and mark remember the last '*' to backtrack to
+	star, mark := -1, 0
+	i, j := 0, 0
+	for j < len(r) {
+		switch {
+		case i < len(p) && (p[i] == '?' || p[i] == r[j]):
+			i++
+			j++
+		case i < len(p) && p[i] == '*':
+			star, mark = i, j
+			i++
+		case star >= 0:
+			mark++
+			i, j = star+1, mark
+		default:
+			return false
+		}
+	}
+	for i < len(p) && p[i] == '*' {
+		i++
+	}
+	return i == len(p)
+}
+
+func (p segmentPattern) match(seg string) bool {
+	switch p.kind {
+	case segmentAny:
+		return true
+	case segmentGlob:
+		for _, alt := range p.alternatives {
+			if globMatch(alt, seg) {
+				return true
+			}
+		}
+		return false
+	}
+	return p.text == seg
+}
+
+func matchSegments(pattern []segmentPattern, key []string) bool {
+	if len(pattern) == 0 {
+		// the filter is padded with empty segments
+		for _, seg := range key {
+			if seg != "" {
+				return false
+			}
+		}
+		return true
+	}
+	p := pattern[0]
+	switch p.kind {
+	case segmentRest:
+		return true
+	case segmentDeep:
+		return matchSegments(pattern[1:], key) || len(key) > 0 && matchSegments(pattern, key[1:])
+	}
+	if len(key) == 0 {
+		// the key is padded with empty segments
+		return p.match("") && matchSegments(pattern[1:], key)
+	}
+	return p.match(key[0]) && matchSegments(pattern[1:], key[1:])
+}
+
+// Match reports whether key matches the filter
+func (f Filter) Match(key string) bool {
+	segs := strings.Split(key, ".")
+	if !matchSegments(f.include, segs) {
+		return false
+	}
+	for _, except := range f.except {
+		if matchSegments(except, segs) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f Filter) String() string {
+	return f.raw
+}
+
+// simple filters only use exact segments, '*' and '>' so the segment index can walk them
+func (f Filter) simple() bool {
+	if len(f.except) > 0 {
+		return false
+	}
+	for _, p := range f.include {
+		if p.kind != segmentLiteral && p.kind != segmentAny && p.kind != segmentRest {
+			return false
+		}
+	}
+	return true
+}
+
+// prefix returns the leading exact segments every key matching the filter starts with
+func (f Filter) prefix() []string {
+	var segs []string
+	for _, p := range f.include {
+		// an empty literal also matches a key that already ended
+		if p.kind != segmentLiteral || p.text == "" {
+			break
+		}
+		segs = append(segs, p.text)
+	}
+	return segs
+}
+
+// KeyMatch reports whether attr matches filter, an invalid filter matches nothing. Filters that are used
+// more than once should be parsed once with ParseFilter
+func KeyMatch(attr string, filter string) bool {
+	f, err := ParseFilter(filter)
+	if err != nil {
+		return false
+	}
+	return f.Match(attr)
+}
@@ -0,0 +1,198 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+)
+
+// Function is an operation a node exposes to the other nodes, it is called with Broker.Call or Context.Call
+// and its id is 'node.name'
+type Function struct {
+	Name string
+	// Args are validated and transformed by their definitions before Fn is called, a missing optional
+	// argument gets the default value of its definition
+	Args []Field
+	// Result validates and transforms what Fn returns, Fn returns nothing when it is nil
+	Result Definition
+	// Fn publishes as the node exposing the function, errors it reports through Context.Error fail the call
+	Fn func(ctx Context, args map[string]interface{}) (interface{}, error)
+}
+
+// FunctionNode is a Node that exposes functions
+type FunctionNode interface {
+	Node
+	NodeFunctions() []Function
+}
+
+// FunctionSchema describes a registered function, as returned by Broker.Functions
+type FunctionSchema struct {
+	FunctionID string        `json:"func"`
+	Owner      string        `json:"owner"`
+	Args       []FieldSchema `json:"args,omitempty"`
+	Result     *Schema       `json:"result,omitempty"`
+}
+
+type functionCtx struct {
+	Function
+	id   string
+	node string
+}
+
+func (fn Function) arguments() ObjectDefinition {
+	return ObjectDefinition{Fields: fn.Args}
+}
+
+func functionsOf(n Node) []Function {
+	if fn, ok := n.(FunctionNode); ok {
+		return fn.NodeFunctions()
+	}
+	return nil
+}
+
+func (ctx *Broker) function(id string) (*functionCtx, bool) {
+	ctx.lock.RLock()
+	defer ctx.lock.RUnlock()
+	fnCtx, ok := ctx.functions[id]
+	return fnCtx, ok
+}
+
+// AddFunction registers a single function exposed by n
+func (ctx *Broker) AddFunction(n Node, fn Function) error {
+	return ctx.register(n, nil, nil, []Function{fn})
+}
+
+// RemoveFunction removes the function with the given id ('node.name'), calls already running are not interrupted
+func (ctx *Broker) RemoveFunction(id string) error {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+	fnCtx, ok := ctx.functions[id]
+	if !ok {
+		return ErrUnknownFunction{Function: id}
+	}
+	ctx.log().Printf("remove function: '%s'", id)
+	ctx.removeFunction(fnCtx)
+	return nil
+}
+
+// removeFunction must be called with the broker lock held
+func (ctx *Broker) removeFunction(fnCtx *functionCtx) {
+	delete(ctx.functions, fnCtx.id)
+	ctx.functionIndex.remove(fnCtx.id, fnCtx.id)
+}
+
+// Functions describes the functions matching filter ordered by id
+func (ctx *Broker) Functions(filter string) []FunctionSchema {
+	ctx.lock.RLock()
+	defer ctx.lock.RUnlock()
+	var schemas []FunctionSchema
+	for _, id := range ctx.functionIndex.matchKeys(filter) {
+		fnCtx := ctx.functions[id]
+		s := FunctionSchema{FunctionID: id, Owner: fnCtx.node}
+		for _, f := range fnCtx.Args {
+			s.Args = append(s.Args, FieldSchema{Name: f.Name, Required: f.Required, Schema: SchemaOf(f.Definition)})
+		}
+		if fnCtx.Result != nil {
+			result := SchemaOf(fnCtx.Result)
+			s.Result = &result
+		}
+		schemas = append(schemas, s)
+	}
+	return schemas
+}
+
+// Call invokes the function with id fn ('node.name') and returns its result, errors from the function
+// are wrapped so errors.As reaches them
+func (ctx *Broker) Call(caller Node, fn string, args map[string]interface{}) (interface{}, error) {
+	return ctx.CallContext(context.Background(), caller, fn, args)
+}
+
+// CallContext is Call bounded by c, when c is done before the function returns the call fails with
+// ErrCallTimeout and the function is left running with a done Context.Context
+func (ctx *Broker) CallContext(c context.Context, caller Node, fn string, args map[string]interface{}) (interface{}, error) {
+	return ctx.invoke(c, caller.NodeId(), fn, args, nil)
+}
+
+// invoke calls fn on behalf of caller, cause is the record being delivered when the call comes from
+// a subscription and is carried on to whatever the function publishes
+func (ctx *Broker) invoke(c context.Context, caller string, fn string, args map[string]interface{}, cause *ValueRecord) (result interface{}, err error) {
+	ctx.log().Printf("call function:'%s' caller:'%s'", fn, caller)
+	defer func() {
+		if err != nil {
+			ctx.log().Printf("error call function:'%s' caller:'%s' err: %s", fn, caller, err)
+		}
+	}()
+	fnCtx, ok := ctx.function(fn)
+	if !ok {
+		err = ErrUnknownFunction{Function: fn}
+		return
+	}
+
+	if args == nil {
+		args = map[string]interface{}{}
+	}
+	transformed, err := fnCtx.arguments().ValidateAndTransform(args)
+	if err != nil {
+		err = fmt.Errorf("invalid arguments %w, thrown by '%s'", err, fn)
+		return
+	}
+	if err = c.Err(); err != nil {
+		err = ErrCallTimeout{Function: fn, Err: err}
+		return
+	}
+
+	execCtx := &executionContext{
+		ctx:       c,
+		broker:    ctx,
+		publisher: fnCtx.node,
+		cause:     cause,
+	}
+	result, err = callWithin(c, func() (interface{}, error) {
+		return fnCtx.Fn(execCtx, transformed.(map[string]interface{}))
+	})
+	if c.Err() != nil {
+		err = ErrCallTimeout{Function: fn, Err: c.Err()}
+		return
+	}
+	if err == nil {
+		if errs := execCtx.Errors(); len(errs) > 0 {
+			err = errs[0]
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("call error %w, thrown by '%s'", err, fn)
+		return
+	}
+
+	if fnCtx.Result == nil {
+		return nil, nil
+	}
+	result, err = fnCtx.Result.ValidateAndTransform(result)
+	if err != nil {
+		err = fmt.Errorf("invalid result %w, thrown by '%s'", err, fn)
+		return
+	}
+	ctx.log().Printf("return function:'%s' result:'%s' caller:'%s'", fn, fnCtx.Result.Inspect(result), caller)
+	return
+}
+
+// callWithin is acceptWithin for a function with a result
+func callWithin(c context.Context, fn func() (interface{}, error)) (interface{}, error) {
+	if c.Done() == nil {
+		return fn()
+	}
+	type response struct {
+		result interface{}
+		err    error
+	}
+	res := make(chan response, 1)
+	go func() {
+		r, err := fn()
+		res <- response{result: r, err: err}
+	}()
+	select {
+	case r := <-res:
+		return r.result, r.err
+	case <-c.Done():
+		return nil, c.Err()
+	}
+}
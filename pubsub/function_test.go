@@ -0,0 +1,172 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCall(t *testing.T) {
+	broker := &Broker{}
+	var got map[string]interface{}
+	light := BasicNode{
+		ID:         "light",
+		Attributes: []Attribute{{Name: "level", Definition: IntegerDefinition{}}},
+		Functions: []Function{{
+			Name: "fade",
+			Args: []Field{
+				{Name: "to", Definition: IntegerDefinition{Constraints: Constraints{Min: Bound(0), Max: Bound(100)}}, Required: true},
+				{Name: "over", Definition: DurationDefinition{}},
+			},
+			Result: StringDefinition{},
+			Fn: func(ctx Context, args map[string]interface{}) (interface{}, error) {
+				got = args
+				if err := ctx.Publish("light.level", args["to"]); err != nil {
+					return nil, err
+				}
+				return "faded", nil
+			},
+		}},
+	}
+	if err := broker.Register(light); err != nil {
+		t.Fatal(err)
+	}
+	app := BasicNode{ID: "app"}
+
+	result, err := broker.Call(app, "light.fade", map[string]interface{}{"to": "40"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "faded" {
+		t.Errorf("expected 'faded' got %v", result)
+	}
+	if got["to"] != int64(40) || got["over"] != time.Duration(0) {
+		t.Errorf("expected transformed arguments with defaults got %#v", got)
+	}
+	if rec, _ := broker.Value("light.level", time.Now()); rec.Inspect() != "40" || rec.UpdatedBy != "light" {
+		t.Errorf("expected the function to publish as its node got %s by %s", rec.Inspect(), rec.UpdatedBy)
+	}
+
+	tests := []struct {
+		Name  string
+		Fn    string
+		Args  map[string]interface{}
+		Check func(err error) bool
+	}{
+		{"unknown function", "light.blink", nil, func(err error) bool { return errors.As(err, &ErrUnknownFunction{}) }},
+		{"missing argument", "light.fade", nil, func(err error) bool { return errors.As(err, &ErrMissingField{}) }},
+		{"unknown argument", "light.fade", map[string]interface{}{"to": 1, "color": "red"}, func(err error) bool { return errors.As(err, &ErrUnknownField{}) }},
+		{"out of range", "light.fade", map[string]interface{}{"to": 101}, func(err error) bool { return errors.As(err, &ErrOutOfRange{}) }},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			if _, err := broker.Call(app, test.Fn, test.Args); !test.Check(err) {
+				t.Errorf("unexpected error %v", err)
+			}
+		})
+	}
+
+	if err := broker.Register(BasicNode{ID: "light", Functions: []Function{{Name: "fade", Fn: light.Functions[0].Fn}}}); !errors.As(err, &ErrDuplicateFunction{}) {
+		t.Errorf("expected ErrDuplicateFunction got %v", err)
+	}
+	if fns := broker.Functions(">"); len(fns) != 1 || fns[0].FunctionID != "light.fade" || len(fns[0].Args) != 2 || fns[0].Result.Type != TypeString {
+		t.Errorf("unexpected functions %+v", fns)
+	}
+	if err := broker.Unregister(light); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := broker.Call(app, "light.fade", map[string]interface{}{"to": 1}); !errors.As(err, &ErrUnknownFunction{}) {
+		t.Errorf("expected ErrUnknownFunction after unregister got %v", err)
+	}
+}
+
+func TestCallErrors(t *testing.T) {
+	broker := &Broker{}
+	failure := errors.New("device offline")
+	release := make(chan struct{})
+	device := BasicNode{ID: "device", Functions: []Function{
+		{Name: "fail", Fn: func(ctx Context, args map[string]interface{}) (interface{}, error) {
+			return nil, failure
+		}},
+		{Name: "report", Fn: func(ctx Context, args map[string]interface{}) (interface{}, error) {
+			ctx.Error(failure)
+			return nil, nil
+		}},
+		{Name: "count", Result: IntegerDefinition{}, Fn: func(ctx Context, args map[string]interface{}) (interface{}, error) {
+			return []string{"many"}, nil
+		}},
+		{Name: "hang", Fn: func(ctx Context, args map[string]interface{}) (interface{}, error) {
+			<-release
+			return nil, nil
+		}},
+	}}
+	if err := broker.Register(device); err != nil {
+		t.Fatal(err)
+	}
+	defer close(release)
+	app := BasicNode{ID: "app"}
+
+	for _, fn := range []string{"device.fail", "device.report"} {
+		if _, err := broker.Call(app, fn, nil); !errors.Is(err, failure) {
+			t.Errorf("%s expected %v got %v", fn, failure, err)
+		}
+	}
+	if _, err := broker.Call(app, "device.count", nil); !errors.As(err, &ErrInvalidType{}) {
+		t.Errorf("expected the result to be validated got %v", err)
+	}
+	c, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := broker.CallContext(c, app, "device.hang", nil); !errors.As(err, &ErrCallTimeout{}) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected ErrCallTimeout got %v", err)
+	}
+}
+
+func TestContextCall(t *testing.T) {
+	broker := &Broker{}
+	relay := BasicNode{
+		ID:         "relay",
+		Attributes: []Attribute{{Name: "on", Definition: BooleanDefinition{}}},
+		Functions: []Function{{
+			Name: "switch",
+			Args: []Field{{Name: "on", Definition: BooleanDefinition{}, Required: true}},
+			Fn: func(ctx Context, args map[string]interface{}) (interface{}, error) {
+				return nil, ctx.Publish("relay.on", args["on"])
+			},
+		}},
+	}
+	sensor := BasicNode{ID: "sensor", Attributes: []Attribute{{Name: "motion", Definition: BooleanDefinition{}}}}
+	automation := BasicNode{ID: "automation", Subscriptions: []Subscription{{
+		Name:   "lights",
+		Filter: "sensor.motion",
+		When:   Condition{Edge: RisingEdge},
+		Fn: func(ctx Context, v Value) {
+			if _, err := ctx.Call("relay.switch", map[string]interface{}{"on": v.Value}); err != nil {
+				ctx.Error(err)
+			}
+		},
+	}}}
+	for _, n := range []BasicNode{relay, sensor, automation} {
+		if err := broker.Register(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := broker.Publish(sensor, "sensor.motion", true); err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err := broker.Value("relay.on", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Value.Value != true {
+		t.Fatalf("expected the call to switch the relay on got %v", rec.Value.Value)
+	}
+	chain, err := broker.CausalChain(rec.Ref())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 2 || chain[1].AttributeID != "sensor.motion" {
+		t.Errorf("expected the publish of the function to be caused by the motion got %+v", chain)
+	}
+}
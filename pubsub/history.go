@@ -0,0 +1,175 @@
+package pubsub
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Aggregate summarizes the records of a numeric attribute in the window [From, To)
+type Aggregate struct {
+	From  time.Time
+	To    time.Time
+	Count int
+	Min   float64
+	Max   float64
+	Mean  float64
+	Last  float64
+	// TimeWeightedAverage weights every value by how long it was in effect inside the window,
+	// including a value carried in from before From. It only covers the part of the window that has a value
+	TimeWeightedAverage float64
+	// Covered is how much of the window had a value, it is zero when TimeWeightedAverage is undefined
+	Covered time.Duration
+}
+
+type AggregateSeries struct {
+	AttributeID string
+	// Unit is the unit of every statistic in Buckets
+	Unit    Unit
+	Buckets []Aggregate
+}
+
+// history returns the records in [from, to) along with the record in effect at from, if any
+func (ctx *attributeCtx) history(from, to time.Time) (carry *ValueRecord, recs []ValueRecord) {
+	ctx.lock.RLock()
+	defer ctx.lock.RUnlock()
+	start := sort.Search(len(ctx.Records), func(i int) bool {
+		return !ctx.Records[i].UpdatedAt.Before(from)
+	})
+	if start > 0 {
+		rec := copyRecord(ctx.Records[start-1])
+		carry = &rec
+	}
+	for i := start; i < len(ctx.Records) && ctx.Records[i].UpdatedAt.Before(to); i++ {
+		recs = append(recs, copyRecord(ctx.Records[i]))
+	}
+	return
+}
+
+// matching returns the attributes matching filter ordered by id
+func (ctx *Broker) matching(filter string) []*attributeCtx {
+	ctx.lock.RLock()
+	defer ctx.lock.RUnlock()
+	var matches []*attributeCtx
+	for _, id := range ctx.attributeIndex.matchKeys(filter) {
+		matches = append(matches, ctx.attributes[id])
+	}
+	return matches
+}
+
+// History returns every retained record of the attributes matching filter published in [from, to),
+// ordered by time and then by attribute
+func (ctx *Broker) History(filter string, from, to time.Time) []ValueRecord {
+	var recs []ValueRecord
+	for _, a := range ctx.matching(filter) {
+		_, r := a.history(from, to)
+		recs = append(recs, r...)
+	}
+	sort.SliceStable(recs, func(i, j int) bool {
+		return recs[i].UpdatedAt.Before(recs[j].UpdatedAt)
+	})
+	return recs
+}
+
+func isNumeric(d Definition) bool {
+	switch d.(type) {
+	case IntegerDefinition, DoubleDefinition:
+		return true
+	}
+	return false
+}
+
+func toFloat(v interface{}) float64 {
+	switch i := v.(type) {
+	case int64:
+		return float64(i)
+	case float64:
+		return i
+	}
+	return 0
+}
+
+// Aggregate summarizes the numeric attributes matching filter over [from, to) in buckets of interval,
+// an interval of zero makes a single bucket. Attributes that are not numeric are skipped
+func (ctx *Broker) Aggregate(filter string, from, to time.Time, interval time.Duration) ([]AggregateSeries, error) {
+	if !from.Before(to) {
+		return nil, fmt.Errorf("invalid window from: %s to: %s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+	if interval <= 0 {
+		interval = to.Sub(from)
+	}
+	now := time.Now()
+
+	var series []AggregateSeries
+	for _, a := range ctx.matching(filter) {
+		if !isNumeric(a.Attribute.Definition) {
+			continue
+		}
+		carry, recs := a.history(from, to)
+		s := AggregateSeries{AttributeID: a.id, Unit: unitOf(a.Attribute.Definition)}
+		for start := from; start.Before(to); start = start.Add(interval) {
+			end := start.Add(interval)
+			if end.After(to) {
+				end = to
+			}
+			var bucket []ValueRecord
+			for len(recs) > 0 && recs[0].UpdatedAt.Before(end) {
+				bucket = append(bucket, recs[0])
+				recs = recs[1:]
+			}
+			s.Buckets = append(s.Buckets, aggregate(start, end, now, carry, bucket))
+			if len(bucket) > 0 {
+				carry = &bucket[len(bucket)-1]
+			}
+		}
+		series = append(series, s)
+	}
+	return series, nil
+}
+
+func aggregate(from, to, now time.Time, carry *ValueRecord, recs []ValueRecord) Aggregate {
+	agg := Aggregate{From: from, To: to, Count: len(recs)}
+	var sum float64
+	for i, rec := range recs {
+		v := toFloat(rec.Value.Value)
+		if i == 0 || v < agg.Min {
+			agg.Min = v
+		}
+		if i == 0 || v > agg.Max {
+			agg.Max = v
+		}
+		sum += v
+		agg.Last = v
+	}
+	if agg.Count > 0 {
+		agg.Mean = sum / float64(agg.Count)
+	}
+
+	// the future has no value yet so the window is cut at now
+	if to.After(now) {
+		to = now
+	}
+	var weighted float64
+	at := from
+	current := carry
+	for i := 0; ; i++ {
+		next := to
+		if i < len(recs) && recs[i].UpdatedAt.Before(to) {
+			next = recs[i].UpdatedAt
+		}
+		if current != nil && next.After(at) {
+			d := next.Sub(at)
+			weighted += toFloat(current.Value.Value) * float64(d)
+			agg.Covered += d
+		}
+		if i >= len(recs) || !recs[i].UpdatedAt.Before(to) {
+			break
+		}
+		current = &recs[i]
+		at = next
+	}
+	if agg.Covered > 0 {
+		agg.TimeWeightedAverage = weighted / float64(agg.Covered)
+	}
+	return agg
+}
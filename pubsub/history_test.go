@@ -0,0 +1,111 @@
+package pubsub
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func historyBroker(t *testing.T, start time.Time) *Broker {
+	n1 := BasicNode{
+		ID: "n1",
+		Attributes: []Attribute{
+			{Name: "temp", Definition: DoubleDefinition{}},
+			{Name: "name", Definition: StringDefinition{}},
+		},
+	}
+	broker := &Broker{}
+	if err := broker.Register(n1); err != nil {
+		t.Fatal(err)
+	}
+	temp, _ := broker.attribute("n1.temp")
+	temp.Records = nil
+	for i, p := range []struct {
+		offset time.Duration
+		value  float64
+	}{
+		{0, 10},
+		{10 * time.Minute, 20},
+		{30 * time.Minute, 40},
+	} {
+		temp.Records = append(temp.Records, &ValueRecord{
+			RecordId: i,
+			Value:    Value{AttributeID: "n1.temp", Value: p.value, UpdatedAt: start.Add(p.offset)},
+		})
+	}
+	name, _ := broker.attribute("n1.name")
+	name.Records = []*ValueRecord{
+		{Value: Value{AttributeID: "n1.name", Value: "x", UpdatedAt: start.Add(20 * time.Minute)}},
+	}
+	return broker
+}
+
+func TestHistory(t *testing.T) {
+	start := time.Now().Add(-2 * time.Hour)
+	broker := historyBroker(t, start)
+
+	recs := broker.History(">", start.Add(5*time.Minute), start.Add(31*time.Minute))
+	if len(recs) != 3 {
+		t.Fatalf("expected 3 records got %d", len(recs))
+	}
+	for i, expected := range []string{"n1.temp", "n1.name", "n1.temp"} {
+		if recs[i].AttributeID != expected {
+			t.Errorf("record %d expected %s got %s", i, expected, recs[i].AttributeID)
+		}
+	}
+
+	if recs := broker.History("n1.temp", start, start.Add(30*time.Minute)); len(recs) != 2 {
+		t.Errorf("expected the end of the window to be exclusive got %d records", len(recs))
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	start := time.Now().Add(-2 * time.Hour)
+	broker := historyBroker(t, start)
+
+	series, err := broker.Aggregate(">", start, start.Add(time.Hour), 30*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(series) != 1 || series[0].AttributeID != "n1.temp" {
+		t.Fatalf("expected only the numeric attribute got %+v", series)
+	}
+	buckets := series[0].Buckets
+	if len(buckets) != 2 {
+		t.Fatalf("expected 2 buckets got %d", len(buckets))
+	}
+
+	b := buckets[0]
+	if b.Count != 2 || b.Min != 10 || b.Max != 20 || b.Mean != 15 || b.Last != 20 {
+		t.Errorf("unexpected first bucket %+v", b)
+	}
+	if math.Abs(b.TimeWeightedAverage-500.0/30) > 1e-9 {
+		t.Errorf("expected a time weighted average of %f got %f", 500.0/30, b.TimeWeightedAverage)
+	}
+	b = buckets[1]
+	if b.Count != 1 || b.Last != 40 || b.TimeWeightedAverage != 40 || b.Covered != 30*time.Minute {
+		t.Errorf("unexpected second bucket %+v", b)
+	}
+
+	// the value in effect before the window is carried in
+	series, err = broker.Aggregate("n1.temp", start.Add(5*time.Minute), start.Add(15*time.Minute), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := series[0].Buckets[0]; b.Count != 1 || b.TimeWeightedAverage != 15 {
+		t.Errorf("unexpected carried in bucket %+v", b)
+	}
+
+	// only the elapsed part of a window reaching into the future is weighted
+	series, err = broker.Aggregate("n1.temp", time.Now().Add(-time.Minute), time.Now().Add(time.Hour), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := series[0].Buckets[0]; b.TimeWeightedAverage != 40 || b.Covered > 2*time.Minute {
+		t.Errorf("unexpected future bucket %+v", b)
+	}
+
+	if _, err := broker.Aggregate(">", start, start, 0); err == nil {
+		t.Error("expected an error for an empty window")
+	}
+}
@@ -0,0 +1,243 @@
+package pubsub
+
+import (
+	"sort"
+	"strings"
+)
+
+// segmentIndex is a trie over dot separated segments. It indexes either filters, to find the filters
+// matching a key, or keys, to find the keys matching a filter, with the same semantics as KeyMatch.
+// Only exact segments, '*' and '>' are walked in the trie, filters using the rest of the syntax are
+// kept aside in patterns and matched one by one
+type segmentIndex struct {
+	root     indexNode
+	patterns map[string]*indexPattern
+}
+
+type indexPattern struct {
+	filter Filter
+	ids    map[string]bool
+}
+
+// pattern returns the parsed filter when it cannot be walked in the trie
+func pattern(key string) (Filter, bool) {
+	f, err := ParseFilter(key)
+	if err != nil || f.simple() {
+		return Filter{}, false
+	}
+	return f, true
+}
+
+type indexNode struct {
+	children map[string]*indexNode
+	ids      map[string]bool
+}
+
+func (n *indexNode) empty() bool {
+	return len(n.children) == 0 && len(n.ids) == 0
+}
+
+func (n *indexNode) collectAll(out map[string]bool) {
+	for id := range n.ids {
+		out[id] = true
+	}
+	for _, child := range n.children {
+		child.collectAll(out)
+	}
+}
+
+func (n *indexNode) collect(out map[string]bool) {
+	for id := range n.ids {
+		out[id] = true
+	}
+}
+
+func (t *segmentIndex) insert(key string, id string) {
+	if f, ok := pattern(key); ok {
+		if t.patterns == nil {
+			t.patterns = make(map[string]*indexPattern)
+		}
+		p, ok := t.patterns[key]
+		if !ok {
+			p = &indexPattern{filter: f, ids: make(map[string]bool)}
+			t.patterns[key] = p
+		}
+		p.ids[id] = true
+		return
+	}
+	n := &t.root
+	for _, seg := range strings.Split(key, ".") {
+		if n.children == nil {
+			n.children = make(map[string]*indexNode)
+		}
+		child, ok := n.children[seg]
+		if !ok {
+			child = &indexNode{}
+			n.children[seg] = child
+		}
+		n = child
+	}
+	if n.ids == nil {
+		n.ids = make(map[string]bool)
+	}
+	n.ids[id] = true
+}
+
+func (t *segmentIndex) remove(key string, id string) {
+	if p, ok := t.patterns[key]; ok {
+		delete(p.ids, id)
+		if len(p.ids) == 0 {
+			delete(t.patterns, key)
+		}
+		return
+	}
+	segs := strings.Split(key, ".")
+	path := make([]*indexNode, 0, len(segs)+1)
+	n := &t.root
+	path = append(path, n)
+	for _, seg := range segs {
+		child, ok := n.children[seg]
+		if !ok {
+			return
+		}
+		n = child
+		path = append(path, n)
+	}
+	delete(n.ids, id)
+	// prune the branches left empty
+	for i := len(segs) - 1; i >= 0; i-- {
+		if !path[i+1].empty() {
+			break
+		}
+		delete(path[i].children, segs[i])
+	}
+}
+
+func sortedIds(set map[string]bool) []string {
+	ids := make([]string, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// matchFilters returns the ids of the indexed filters that match key, sorted
+func (t *segmentIndex) matchFilters(key string) []string {
+	segs := strings.Split(key, ".")
+	// a filter that ends early only matches if the rest of the key is empty, like KeyMatch padding it
+	emptyFrom := len(segs)
+	for emptyFrom > 0 && segs[emptyFrom-1] == "" {
+		emptyFrom--
+	}
+	out := make(map[string]bool)
+	t.root.matchFilters(segs, 0, emptyFrom, out)
+	for _, p := range t.patterns {
+		if p.filter.Match(key) {
+			for id := range p.ids {
+				out[id] = true
+			}
+		}
+	}
+	return sortedIds(out)
+}
+
+func (n *indexNode) matchFilters(segs []string, i int, emptyFrom int, out map[string]bool) {
+	// '>' matches whatever follows it, including segments after the '>' in the filter itself
+	if gt, ok := n.children[">"]; ok {
+		gt.collectAll(out)
+	}
+	if i >= emptyFrom {
+		n.collect(out)
+	}
+	seg := ""
+	if i < len(segs) {
+		seg = segs[i]
+	}
+	if child, ok := n.children[seg]; ok && seg != "*" && seg != ">" {
+		child.matchFilters(segs, i+1, emptyFrom, out)
+	}
+	// past the end of the key only missing segments are left, which '*' also matches
+	if star, ok := n.children["*"]; ok {
+		star.matchFilters(segs, i+1, emptyFrom, out)
+	}
+}
+
+// matchKeys returns the ids of the indexed keys that match filter, sorted
+func (t *segmentIndex) matchKeys(filter string) []string {
+	if f, ok := pattern(filter); ok {
+		return t.matchPattern(f)
+	}
+	segs := strings.Split(filter, ".")
+	// tailEmpty[i] is set when the filter from i on matches a key that already ended
+	tailEmpty := make([]bool, len(segs)+1)
+	tailEmpty[len(segs)] = true
+	for i := len(segs) - 1; i >= 0; i-- {
+		switch segs[i] {
+		case ">":
+			tailEmpty[i] = true
+		case "*", "":
+			tailEmpty[i] = tailEmpty[i+1]
+		}
+	}
+	out := make(map[string]bool)
+	t.root.matchKeys(segs, 0, tailEmpty, out)
+	return sortedIds(out)
+}
+
+func (n *indexNode) matchKeys(segs []string, i int, tailEmpty []bool, out map[string]bool) {
+	if i >= len(segs) {
+		n.collect(out)
+		// keys longer than the filter only match if the rest of the key is empty
+		if child, ok := n.children[""]; ok {
+			child.matchKeys(segs, i, tailEmpty, out)
+		}
+		return
+	}
+	if tailEmpty[i] {
+		n.collect(out)
+	}
+	switch segs[i] {
+	case ">":
+		for _, child := range n.children {
+			child.collectAll(out)
+		}
+	case "*":
+		for _, child := range n.children {
+			child.matchKeys(segs, i+1, tailEmpty, out)
+		}
+	default:
+		if child, ok := n.children[segs[i]]; ok {
+			child.matchKeys(segs, i+1, tailEmpty, out)
+		}
+	}
+}
+
+// matchPattern walks the keys under the exact prefix of f and matches each of them
+func (t *segmentIndex) matchPattern(f Filter) []string {
+	n := &t.root
+	prefix := f.prefix()
+	for _, seg := range prefix {
+		child, ok := n.children[seg]
+		if !ok {
+			return nil
+		}
+		n = child
+	}
+	out := make(map[string]bool)
+	n.matchPattern(f, strings.Join(prefix, "."), len(prefix) > 0, out)
+	return sortedIds(out)
+}
+
+func (n *indexNode) matchPattern(f Filter, key string, started bool, out map[string]bool) {
+	if len(n.ids) > 0 && f.Match(key) {
+		n.collect(out)
+	}
+	for seg, child := range n.children {
+		childKey := seg
+		if started {
+			childKey = key + "." + seg
+		}
+		child.matchPattern(f, childKey, true, out)
+	}
+}
@@ -0,0 +1,198 @@
+package pubsub
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func randomKey(r *rand.Rand, segs []string) string {
+	parts := make([]string, 1+r.Intn(4))
+	for i := range parts {
+		parts[i] = segs[r.Intn(len(segs))]
+	}
+	return strings.Join(parts, ".")
+}
+
+func TestSegmentIndexMatchesKeyMatch(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	keySegs := []string{"a", "b", "c", ""}
+	filterSegs := []string{"a", "b", "c", "", "*", ">"}
+
+	var keys, filters []string
+	for i := 0; i < 200; i++ {
+		keys = append(keys, randomKey(r, keySegs))
+		filters = append(filters, randomKey(r, filterSegs))
+	}
+
+	var byFilter, byKey segmentIndex
+	for i, f := range filters {
+		byFilter.insert(f, fmt.Sprint(i))
+	}
+	for i, k := range keys {
+		byKey.insert(k, fmt.Sprint(i))
+	}
+
+	for _, k := range keys {
+		var expected []string
+		for i, f := range filters {
+			if KeyMatch(k, f) {
+				expected = append(expected, fmt.Sprint(i))
+			}
+		}
+		if got := byFilter.matchFilters(k); fmt.Sprint(sortedIds(toSet(expected))) != fmt.Sprint(got) {
+			t.Errorf("key:'%s' expected filters %v got %v", k, sortedIds(toSet(expected)), got)
+		}
+	}
+	for _, f := range filters {
+		var expected []string
+		for i, k := range keys {
+			if KeyMatch(k, f) {
+				expected = append(expected, fmt.Sprint(i))
+			}
+		}
+		if got := byKey.matchKeys(f); fmt.Sprint(sortedIds(toSet(expected))) != fmt.Sprint(got) {
+			t.Errorf("filter:'%s' expected keys %v got %v", f, sortedIds(toSet(expected)), got)
+		}
+	}
+
+	// removing everything leaves an empty trie behind
+	for i, f := range filters {
+		byFilter.remove(f, fmt.Sprint(i))
+	}
+	if !byFilter.root.empty() {
+		t.Error("expected the index to be empty after removing every filter")
+	}
+}
+
+func TestSegmentIndexPatterns(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	keySegs := []string{"a", "b", "ab", "c"}
+	filterSegs := []string{"a", "b", "*", ">", "**", "{a,c}", "a*", "?"}
+
+	var keys, filters []string
+	for i := 0; i < 200; i++ {
+		keys = append(keys, randomKey(r, keySegs))
+		f := randomKey(r, filterSegs)
+		if r.Intn(4) == 0 {
+			f += " except " + randomKey(r, filterSegs)
+		}
+		filters = append(filters, f)
+	}
+
+	var byFilter, byKey segmentIndex
+	for i, f := range filters {
+		byFilter.insert(f, fmt.Sprint(i))
+	}
+	for i, k := range keys {
+		byKey.insert(k, fmt.Sprint(i))
+	}
+
+	for _, k := range keys {
+		expected := make(map[string]bool)
+		for i, f := range filters {
+			if KeyMatch(k, f) {
+				expected[fmt.Sprint(i)] = true
+			}
+		}
+		if got := byFilter.matchFilters(k); fmt.Sprint(sortedIds(expected)) != fmt.Sprint(got) {
+			t.Errorf("key:'%s' expected filters %v got %v", k, sortedIds(expected), got)
+		}
+	}
+	for _, f := range filters {
+		expected := make(map[string]bool)
+		for i, k := range keys {
+			if KeyMatch(k, f) {
+				expected[fmt.Sprint(i)] = true
+			}
+		}
+		if got := byKey.matchKeys(f); fmt.Sprint(sortedIds(expected)) != fmt.Sprint(got) {
+			t.Errorf("filter:'%s' expected keys %v got %v", f, sortedIds(expected), got)
+		}
+	}
+
+	for i, f := range filters {
+		byFilter.remove(f, fmt.Sprint(i))
+	}
+	if !byFilter.root.empty() || len(byFilter.patterns) != 0 {
+		t.Error("expected the index to be empty after removing every filter")
+	}
+}
+
+func toSet(ids []string) map[string]bool {
+	set := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
+
+// benchmarkFilters builds the subscriptions of a house full of devices, most subscribing to their own
+// attributes and a handful of dashboards using wildcards
+func benchmarkFilters(devices int) (keys []string, filters []string) {
+	for d := 0; d < devices; d++ {
+		for _, attr := range []string{"gpio.0", "gpio.1", "adc.0", "config.name", "temperature"} {
+			keys = append(keys, fmt.Sprintf("dev%d.%s", d, attr))
+		}
+		filters = append(filters,
+			fmt.Sprintf("dev%d.gpio.*", d),
+			fmt.Sprintf("dev%d.>", d),
+			fmt.Sprintf("*.%s", "temperature"),
+		)
+	}
+	filters = append(filters, ">", "*.gpio.0", "dev1.>")
+	return
+}
+
+func BenchmarkFanoutLinear(b *testing.B) {
+	keys, filters := benchmarkFilters(500)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := keys[i%len(keys)]
+		var matches []string
+		for _, f := range filters {
+			if KeyMatch(key, f) {
+				matches = append(matches, f)
+			}
+		}
+	}
+}
+
+func BenchmarkFanoutIndex(b *testing.B) {
+	keys, filters := benchmarkFilters(500)
+	var index segmentIndex
+	for i, f := range filters {
+		index.insert(f, fmt.Sprint(i))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index.matchFilters(keys[i%len(keys)])
+	}
+}
+
+func BenchmarkValuesLinear(b *testing.B) {
+	keys, filters := benchmarkFilters(500)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		filter := filters[i%len(filters)]
+		var matches []string
+		for _, k := range keys {
+			if KeyMatch(k, filter) {
+				matches = append(matches, k)
+			}
+		}
+	}
+}
+
+func BenchmarkValuesIndex(b *testing.B) {
+	keys, filters := benchmarkFilters(500)
+	var index segmentIndex
+	for _, k := range keys {
+		index.insert(k, k)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index.matchKeys(filters[i%len(filters)])
+	}
+}
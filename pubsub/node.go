@@ -10,6 +10,7 @@ type BasicNode struct {
 	ID            string
 	Attributes    []Attribute
 	Subscriptions []Subscription
+	Functions     []Function
 }
 
 func (n BasicNode) NodeId() string {
@@ -21,3 +22,6 @@ func (n BasicNode) NodeAttributes() []Attribute {
 func (n BasicNode) NodeSubscriptions() []Subscription {
 	return n.Subscriptions
 }
+func (n BasicNode) NodeFunctions() []Function {
+	return n.Functions
+}
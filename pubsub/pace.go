@@ -0,0 +1,131 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// paceState is the pacing of one attribute for one subscription
+type paceState struct {
+	last    time.Time
+	pending *heldDelivery
+	timer   *time.Timer
+}
+
+func (d Delivery) paced() bool {
+	return d.Throttle > 0 || d.Debounce > 0 || d.Sample > 0
+}
+
+func (d Delivery) validate() error {
+	set := 0
+	for _, interval := range []time.Duration{d.Throttle, d.Debounce, d.Sample} {
+		if interval < 0 {
+			return fmt.Errorf("negative delivery interval")
+		}
+		if interval > 0 {
+			set++
+		}
+	}
+	if set > 1 {
+		return fmt.Errorf("only one of throttle, debounce and sample can be set")
+	}
+	return nil
+}
+
+// dispatch delivers rec to sub right away or through its pacing
+func (ctx *Broker) dispatch(c context.Context, id string, sub *subscriptionCtx, publisher string, recCtx *attributeCtx, rec *ValueRecord) {
+	if sub.Delivery.paced() {
+		ctx.pace(id, sub, heldDelivery{c: detachedContext{c}, publisher: publisher, recCtx: recCtx, rec: rec})
+		return
+	}
+	ctx.deliver(c, id, sub, publisher, recCtx, rec, false)
+}
+
+func (ctx *Broker) deliver(c context.Context, id string, sub *subscriptionCtx, publisher string, recCtx *attributeCtx, rec *ValueRecord, snapshot bool) {
+	if sub.queue != nil {
+		ctx.enqueue(c, id, sub, publisher, recCtx, rec, snapshot)
+	} else {
+		ctx.callAndRespond(c, id, sub, publisher, recCtx, rec, snapshot)
+	}
+}
+
+// pace applies the throttle, debounce or sample interval of sub to the attribute of d
+func (ctx *Broker) pace(id string, sub *subscriptionCtx, d heldDelivery) {
+	sub.lock.Lock()
+	if sub.removed {
+		sub.lock.Unlock()
+		return
+	}
+	if sub.pacing == nil {
+		sub.pacing = make(map[*attributeCtx]*paceState)
+	}
+	state, ok := sub.pacing[d.recCtx]
+	if !ok {
+		state = &paceState{}
+		sub.pacing[d.recCtx] = state
+	}
+	now := time.Now()
+	fire := func() { ctx.flushPace(id, sub, d.recCtx) }
+
+	switch {
+	case sub.Delivery.Throttle > 0:
+		if state.timer == nil && now.Sub(state.last) >= sub.Delivery.Throttle {
+			state.last = now
+			sub.lock.Unlock()
+			ctx.deliver(d.c, id, sub, d.publisher, d.recCtx, d.rec, false)
+			return
+		}
+		state.pending = &d
+		if state.timer == nil {
+			state.timer = time.AfterFunc(state.last.Add(sub.Delivery.Throttle).Sub(now), fire)
+		}
+	case sub.Delivery.Debounce > 0:
+		state.pending = &d
+		if state.timer != nil {
+			state.timer.Stop()
+		}
+		state.timer = time.AfterFunc(sub.Delivery.Debounce, fire)
+	case sub.Delivery.Sample > 0:
+		state.pending = &d
+		if state.timer == nil {
+			state.timer = time.AfterFunc(sub.Delivery.Sample, fire)
+		}
+	}
+	sub.lock.Unlock()
+}
+
+// flushPace delivers the pending value of an attribute once its interval is over
+func (ctx *Broker) flushPace(id string, sub *subscriptionCtx, recCtx *attributeCtx) {
+	sub.lock.Lock()
+	state, ok := sub.pacing[recCtx]
+	if !ok || sub.removed {
+		sub.lock.Unlock()
+		return
+	}
+	d := state.pending
+	state.pending = nil
+	state.timer = nil
+	if d != nil {
+		state.last = time.Now()
+		// sampling goes on as long as values keep coming
+		if sub.Delivery.Sample > 0 {
+			state.timer = time.AfterFunc(sub.Delivery.Sample, func() { ctx.flushPace(id, sub, recCtx) })
+		}
+	}
+	sub.lock.Unlock()
+
+	if d != nil && sub.active() {
+		ctx.deliver(d.c, id, sub, d.publisher, d.recCtx, d.rec, false)
+	}
+}
+
+// stopPacing drops the pending values, sub.lock held
+func (sub *subscriptionCtx) stopPacing() {
+	for _, state := range sub.pacing {
+		if state.timer != nil {
+			state.timer.Stop()
+		}
+	}
+	sub.pacing = nil
+}
@@ -0,0 +1,93 @@
+package pubsub
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSubscriptionPacing(t *testing.T) {
+	const interval = 100 * time.Millisecond
+	tests := []struct {
+		Name     string
+		Delivery Delivery
+		// Immediate is what is delivered right after the burst, Eventually after the interval
+		Immediate  []string
+		Eventually []string
+	}{
+		{"none", Delivery{}, []string{"a=1", "a=2", "a=3", "b=1"}, []string{"a=1", "a=2", "a=3", "b=1"}},
+		{"throttle", Delivery{Throttle: interval}, []string{"a=1", "b=1"}, []string{"a=1", "b=1", "a=3"}},
+		{"throttle async", Delivery{Async: true, Throttle: interval}, []string{"a=1", "b=1"}, []string{"a=1", "b=1", "a=3"}},
+		{"debounce", Delivery{Debounce: interval}, nil, []string{"a=3", "b=1"}},
+		{"sample", Delivery{Sample: interval}, nil, []string{"a=3", "b=1"}},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			broker := &Broker{}
+			sensor := BasicNode{ID: "sensor", Attributes: []Attribute{
+				{Name: "a", Definition: IntegerDefinition{}},
+				{Name: "b", Definition: IntegerDefinition{}},
+			}}
+			if err := broker.Register(sensor); err != nil {
+				t.Fatal(err)
+			}
+			var lock sync.Mutex
+			var got []string
+			sub := Subscription{Name: "watch", Filter: "sensor.>", Delivery: test.Delivery, Fn: func(ctx Context, v Value) {
+				lock.Lock()
+				got = append(got, v.AttributeID[len("sensor."):]+"="+v.Inspect())
+				lock.Unlock()
+			}}
+			if err := broker.Subscribe(BasicNode{ID: "dashboard"}, sub); err != nil {
+				t.Fatal(err)
+			}
+			for _, p := range []struct {
+				attr  string
+				value int
+			}{{"sensor.a", 1}, {"sensor.a", 2}, {"sensor.b", 1}, {"sensor.a", 3}} {
+				if err := broker.Publish(sensor, p.attr, p.value); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			sorted := func(vs []string) string {
+				// deliveries of different attributes may interleave either way
+				a, b := []string{}, []string{}
+				for _, v := range vs {
+					if v[0] == 'a' {
+						a = append(a, v)
+					} else {
+						b = append(b, v)
+					}
+				}
+				return fmt.Sprint(a, b)
+			}
+			time.Sleep(interval / 4)
+			lock.Lock()
+			if sorted(got) != sorted(test.Immediate) {
+				t.Errorf("expected %v right away got %v", test.Immediate, got)
+			}
+			lock.Unlock()
+			time.Sleep(interval * 2)
+			lock.Lock()
+			if sorted(got) != sorted(test.Eventually) {
+				t.Errorf("expected %v eventually got %v", test.Eventually, got)
+			}
+			lock.Unlock()
+		})
+	}
+}
+
+func TestSubscriptionPacingValidation(t *testing.T) {
+	broker := &Broker{}
+	fn := func(ctx Context, v Value) {}
+	for i, d := range []Delivery{
+		{Throttle: time.Second, Debounce: time.Second},
+		{Sample: -time.Second},
+	} {
+		if err := broker.Subscribe(BasicNode{ID: "n"}, Subscription{Name: fmt.Sprintf("s%d", i), Filter: ">", Fn: fn, Delivery: d}); err == nil {
+			t.Errorf("%d expected %+v to be rejected", i, d)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package pubsub
+
+import (
+	"time"
+)
+
+// RetentionPolicy bounds the history kept for an attribute, the zero value keeps everything.
+// The newest record of an attribute is always kept regardless of the policy
+type RetentionPolicy struct {
+	MaxRecords int
+	MaxAge     time.Duration
+	Downsample []DownsampleRule
+}
+
+// DownsampleRule thins records older than After down to the last record of every Every sized bucket
+type DownsampleRule struct {
+	After time.Duration
+	Every time.Duration
+}
+
+type retentionFilter struct {
+	Filter Filter
+	Policy RetentionPolicy
+}
+
+func (p RetentionPolicy) isZero() bool {
+	return p.MaxRecords <= 0 && p.MaxAge <= 0 && len(p.Downsample) == 0
+}
+
+// compactInterval is how often the downsample rules are applied, they walk the whole history
+// so they run at the rate of the finest rule instead of on every publish
+func (p RetentionPolicy) compactInterval() time.Duration {
+	var interval time.Duration
+	for _, rule := range p.Downsample {
+		if rule.Every > 0 && (interval == 0 || rule.Every < interval) {
+			interval = rule.Every
+		}
+	}
+	return interval
+}
+
+// trim drops records exceeding MaxRecords or MaxAge and returns how many were dropped
+func (p RetentionPolicy) trim(recs []*ValueRecord, now time.Time) int {
+	drop := 0
+	if p.MaxRecords > 0 && len(recs) > p.MaxRecords {
+		drop = len(recs) - p.MaxRecords
+	}
+	if p.MaxAge > 0 {
+		cutoff := now.Add(-p.MaxAge)
+		for drop < len(recs)-1 && recs[drop].UpdatedAt.Before(cutoff) {
+			drop++
+		}
+	}
+	if drop >= len(recs) {
+		drop = len(recs) - 1
+	}
+	if drop < 0 {
+		drop = 0
+	}
+	return drop
+}
+
+func (p RetentionPolicy) downsample(recs []*ValueRecord, now time.Time) []*ValueRecord {
+	for _, rule := range p.Downsample {
+		if rule.Every <= 0 {
+			continue
+		}
+		cutoff := now.Add(-rule.After)
+		kept := recs[:0]
+		for i, rec := range recs {
+			if rec.UpdatedAt.Before(cutoff) && i+1 < len(recs) &&
+				rec.UpdatedAt.Truncate(rule.Every).Equal(recs[i+1].UpdatedAt.Truncate(rule.Every)) {
+				continue
+			}
+			kept = append(kept, rec)
+		}
+		for i := len(kept); i < len(recs); i++ {
+			recs[i] = nil
+		}
+		recs = kept
+	}
+	return recs
+}
+
+// SetRetention applies policy to every attribute matching filter, including ones registered later.
+// When several filters match an attribute the one set last wins, a policy set on the Attribute itself always wins.
+// An invalid filter fails with ErrInvalidFilter, an attribute failing to apply the policy is only logged
+func (ctx *Broker) SetRetention(filter string, policy RetentionPolicy) error {
+	f, err := ParseFilter(filter)
+	if err != nil {
+		return err
+	}
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+	ctx.retention = append(ctx.retention, retentionFilter{Filter: f, Policy: policy})
+	for _, id := range ctx.attributeIndex.matchKeys(filter) {
+		if recCtx := ctx.attributes[id]; recCtx.Attribute.Retention.isZero() {
+			if err := recCtx.setRetention(policy); err != nil {
+				ctx.log().Printf("error retention attribute:'%s' err: %s", id, err)
+			}
+		}
+	}
+	return nil
+}
+
+// retentionFor must be called with the broker lock held
+func (ctx *Broker) retentionFor(id string, attr Attribute) RetentionPolicy {
+	if !attr.Retention.isZero() {
+		return attr.Retention
+	}
+	for i := len(ctx.retention) - 1; i >= 0; i-- {
+		if ctx.retention[i].Filter.Match(id) {
+			return ctx.retention[i].Policy
+		}
+	}
+	return RetentionPolicy{}
+}
+
+func (ctx *attributeCtx) setRetention(policy RetentionPolicy) error {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+	ctx.retention = policy
+	ctx.compactedAt = time.Time{}
+	return ctx.applyRetention(time.Now())
+}
+
+// applyRetention must be called with the attribute lock held, dropped records are also dropped from the store
+func (ctx *attributeCtx) applyRetention(now time.Time) error {
+	if ctx.retention.isZero() || len(ctx.Records) == 0 {
+		return nil
+	}
+	var dropped []int
+	if drop := ctx.retention.trim(ctx.Records, now); drop > 0 {
+		for i := 0; i < drop; i++ {
+			dropped = append(dropped, ctx.Records[i].RecordId)
+			ctx.Records[i] = nil
+		}
+		ctx.Records = ctx.Records[drop:]
+		ctx.pruned = true
+	}
+	if interval := ctx.retention.compactInterval(); interval > 0 && now.Sub(ctx.compactedAt) >= interval {
+		ctx.compactedAt = now
+		if n := len(ctx.Records); n > 0 {
+			kept := make(map[int]bool, n)
+			recs := ctx.retention.downsample(append([]*ValueRecord(nil), ctx.Records...), now)
+			for _, rec := range recs {
+				kept[rec.RecordId] = true
+			}
+			for _, rec := range ctx.Records {
+				if !kept[rec.RecordId] {
+					dropped = append(dropped, rec.RecordId)
+				}
+			}
+			ctx.Records = recs
+			ctx.pruned = ctx.pruned || len(ctx.Records) != n
+		}
+	}
+	if ctx.store != nil && len(dropped) > 0 {
+		return ctx.store.Drop(ctx.id, dropped)
+	}
+	return nil
+}
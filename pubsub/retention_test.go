@@ -0,0 +1,152 @@
+package pubsub
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func testRecords(start time.Time, step time.Duration, n int) []*ValueRecord {
+	recs := make([]*ValueRecord, n)
+	for i := range recs {
+		recs[i] = &ValueRecord{
+			RecordId: i,
+			Value:    Value{Value: i, UpdatedAt: start.Add(time.Duration(i) * step)},
+		}
+	}
+	return recs
+}
+
+func TestRetentionMaxRecords(t *testing.T) {
+	n1 := BasicNode{
+		ID: "n1",
+		Attributes: []Attribute{
+			{Name: "a1", Definition: DoubleDefinition{}, Retention: RetentionPolicy{MaxRecords: 3}},
+		},
+	}
+	broker := &Broker{}
+	if err := broker.Register(n1); err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now()
+	time.Sleep(time.Millisecond)
+	for i := 1; i <= 10; i++ {
+		if err := broker.Publish(n1, "n1.a1", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recCtx, _ := broker.attribute("n1.a1")
+	if len(recCtx.Records) != 3 {
+		t.Fatalf("expected 3 records got %d", len(recCtx.Records))
+	}
+	rec, err := broker.Value("n1.a1", time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.RecordId != 10 || rec.Value.Value != 10.0 {
+		t.Errorf("expected record 10 with value 10 got %d %v", rec.RecordId, rec.Value.Value)
+	}
+	var notRetained ErrNotRetained
+	if _, err := broker.Value("n1.a1", before); !errors.As(err, &notRetained) {
+		t.Errorf("expected ErrNotRetained got %v", err)
+	}
+}
+
+func TestRetentionFilter(t *testing.T) {
+	broker := &Broker{}
+	if err := broker.SetRetention("n1.>", RetentionPolicy{MaxRecords: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.SetRetention("n1.{a1", RetentionPolicy{MaxRecords: 2}); !errors.As(err, &ErrInvalidFilter{}) {
+		t.Errorf("expected ErrInvalidFilter got %v", err)
+	}
+	n1 := BasicNode{
+		ID: "n1",
+		Attributes: []Attribute{
+			{Name: "a1", Definition: StringDefinition{}},
+			{Name: "a2", Definition: StringDefinition{}, Retention: RetentionPolicy{MaxRecords: 5}},
+		},
+	}
+	if err := broker.Register(n1); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		broker.Publish(n1, "n1.a1", "x")
+		broker.Publish(n1, "n1.a2", "x")
+	}
+	a1, _ := broker.attribute("n1.a1")
+	a2, _ := broker.attribute("n1.a2")
+	if len(a1.Records) != 1 {
+		t.Errorf("expected 1 record for n1.a1 got %d", len(a1.Records))
+	}
+	if len(a2.Records) != 5 {
+		t.Errorf("expected 5 records for n1.a2 got %d", len(a2.Records))
+	}
+}
+
+func TestRetentionMaxAge(t *testing.T) {
+	now := time.Now()
+	ctx := &attributeCtx{
+		id:        "n1.a1",
+		retention: RetentionPolicy{MaxAge: time.Hour},
+		Records:   testRecords(now.Add(-3*time.Hour), time.Minute, 150),
+	}
+	ctx.applyRetention(now)
+	if first := ctx.Records[0].UpdatedAt; first.Before(now.Add(-time.Hour)) {
+		t.Errorf("expected no records older than an hour got %s", first)
+	}
+	if len(ctx.Records) != 30 {
+		t.Errorf("expected 30 records got %d", len(ctx.Records))
+	}
+
+	// the newest record is kept even when it is too old
+	ctx.applyRetention(now.Add(24 * time.Hour))
+	if len(ctx.Records) != 1 || ctx.Records[0].RecordId != 149 {
+		t.Errorf("expected only the newest record to be kept got %d", len(ctx.Records))
+	}
+	if _, err := ctx.Value(now.Add(time.Hour)); err != nil {
+		t.Error(err)
+	}
+	var notRetained ErrNotRetained
+	if _, err := ctx.Value(now.Add(-2 * time.Hour)); !errors.As(err, &notRetained) {
+		t.Errorf("expected ErrNotRetained got %v", err)
+	}
+}
+
+func TestRetentionDownsample(t *testing.T) {
+	now := time.Now().Truncate(time.Minute)
+	ctx := &attributeCtx{
+		id: "n1.a1",
+		retention: RetentionPolicy{
+			Downsample: []DownsampleRule{
+				{After: time.Hour, Every: time.Minute},
+			},
+		},
+		Records: testRecords(now.Add(-2*time.Hour), time.Second, 2*60*60),
+	}
+	ctx.applyRetention(now)
+
+	var old, recent int
+	for _, rec := range ctx.Records {
+		if rec.UpdatedAt.Before(now.Add(-time.Hour)) {
+			old++
+		} else {
+			recent++
+		}
+	}
+	if old != 60 {
+		t.Errorf("expected 60 downsampled records got %d", old)
+	}
+	if recent != 60*60 {
+		t.Errorf("expected %d untouched records got %d", 60*60, recent)
+	}
+
+	// the last record of every minute is kept so values inside the downsampled window still resolve
+	rec, err := ctx.Value(now.Add(-90 * time.Minute).Add(30 * time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := now.Add(-91 * time.Minute).Add(59 * time.Second); !rec.UpdatedAt.Equal(expected) {
+		t.Errorf("expected record at %s got %s", expected, rec.UpdatedAt)
+	}
+}
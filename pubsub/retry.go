@@ -0,0 +1,190 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// defaultBackoffMultiplier is used by retry policies that do not set Multiplier
+const defaultBackoffMultiplier = 2
+
+// RetryPolicy calls a subscription again with the same value when it reported errors through Context.Error.
+// Retries run on their own timer, the values published meanwhile are still delivered so a retried value
+// can reach Fn after newer ones. An asynchronous subscription gets its retries through its queue so its
+// worker remains the only one calling Fn
+type RetryPolicy struct {
+	// MaxAttempts counts the first call, zero or one never retries. The zero policy neither retries
+	// nor publishes dead letters
+	MaxAttempts int
+	// Backoff is the wait before the first retry, every retry after that waits Multiplier times longer
+	// up to MaxBackoff when it is set
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Multiplier float64
+	// DeadLetter is the id of an attribute holding a DeadLetterDefinition, a value that failed every
+	// attempt is published to it as a DeadLetter. Without it the value is only logged
+	DeadLetter string
+}
+
+// DeadLetter is a value a subscription failed to handle, see RetryPolicy.DeadLetter
+type DeadLetter struct {
+	Subscription string
+	Record       RecordRef
+	// Value is the value as the attribute inspected it
+	Value    string
+	Errors   []string
+	Attempts int
+}
+
+// DeadLetterDefinition is the definition of a dead letter attribute, its values are maps that DeadLetterOf reads
+func DeadLetterDefinition() ObjectDefinition {
+	return ObjectDefinition{Fields: []Field{
+		{Name: "subscription", Definition: StringDefinition{}, Required: true},
+		{Name: "attribute", Definition: StringDefinition{}, Required: true},
+		{Name: "record", Definition: IntegerDefinition{}, Required: true},
+		{Name: "value", Definition: StringDefinition{}},
+		{Name: "errors", Definition: ArrayDefinition{Element: StringDefinition{}}},
+		{Name: "attempts", Definition: IntegerDefinition{}},
+	}}
+}
+
+// DeadLetterOf reads a value of an attribute with a DeadLetterDefinition
+func DeadLetterOf(v Value) (DeadLetter, error) {
+	transformed, err := DeadLetterDefinition().ValidateAndTransform(v.Value)
+	if err != nil {
+		return DeadLetter{}, err
+	}
+	m := transformed.(map[string]interface{})
+	dl := DeadLetter{
+		Subscription: m["subscription"].(string),
+		Record:       RecordRef{AttributeID: m["attribute"].(string), RecordId: int(m["record"].(int64))},
+		Value:        m["value"].(string),
+		Attempts:     int(m["attempts"].(int64)),
+	}
+	for _, e := range m["errors"].([]interface{}) {
+		dl.Errors = append(dl.Errors, e.(string))
+	}
+	return dl, nil
+}
+
+func (p RetryPolicy) isZero() bool {
+	return p.MaxAttempts == 0 && p.DeadLetter == ""
+}
+
+func (p RetryPolicy) validate() error {
+	if p.MaxAttempts < 0 || p.Backoff < 0 || p.MaxBackoff < 0 {
+		return fmt.Errorf("retry policy cannot be negative")
+	}
+	if p.Multiplier != 0 && p.Multiplier < 1 {
+		return fmt.Errorf("retry multiplier must be at least 1")
+	}
+	if p.DeadLetter != "" {
+		if err := ValidateName(p.DeadLetter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backoff returns the wait before the attempt following attempt
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier == 0 {
+		multiplier = defaultBackoffMultiplier
+	}
+	d := float64(p.Backoff)
+	for i := 1; i < attempt; i++ {
+		d *= multiplier
+		if p.MaxBackoff > 0 && d >= float64(p.MaxBackoff) {
+			return p.MaxBackoff
+		}
+	}
+	return time.Duration(d)
+}
+
+// retrying reports whether attempt is followed by another one, the response of the attempt is recorded
+// along with it so it never looks settled in between
+func (sub *subscriptionCtx) retrying(attempt int, errs []error) bool {
+	return len(errs) > 0 && attempt < sub.Retry.MaxAttempts && sub.active()
+}
+
+// retry follows up on attempt of delivering rec to sub which reported errs, by scheduling another
+// attempt or once there are none left by publishing a dead letter. i is the response of sub on rec
+func (ctx *Broker) retry(c context.Context, id string, sub *subscriptionCtx, publisher string, recCtx *attributeCtx, rec *ValueRecord, i int, attempt int, errs []error) {
+	if len(errs) == 0 || sub.Retry.isZero() || !sub.active() {
+		return
+	}
+	if !sub.retrying(attempt, errs) {
+		ctx.deadLetter(c, id, sub, recCtx, rec, i)
+		return
+	}
+	wait := sub.Retry.backoff(attempt)
+	ctx.log().Printf("retry subscription:'%s' attribute:'%s' attempt: %d in: %s", id, rec.AttributeID, attempt+1, wait)
+	// the publish that triggered the first attempt is long gone when the retry runs
+	c = detachedContext{parent: c}
+	time.AfterFunc(wait, func() {
+		if !sub.active() {
+			ctx.log().Printf("skip retry removed subscription:'%s' attribute:'%s'", id, rec.AttributeID)
+			recCtx.updateResponse(rec, i, func(res *SubscriptionResponse) {
+				res.Retrying = false
+			})
+			return
+		}
+		if sub.queue != nil {
+			ctx.requeue(c, id, sub, publisher, recCtx, rec, i, attempt+1)
+			return
+		}
+		errs := ctx.call(c, id, sub, publisher, recCtx, rec)
+		recCtx.updateResponse(rec, i, func(res *SubscriptionResponse) {
+			res.Retrying = sub.retrying(attempt+1, errs)
+			res.Attempts = attempt + 1
+			res.Err = append(res.Err, errs...)
+		})
+		ctx.retry(c, id, sub, publisher, recCtx, rec, i, attempt+1, errs)
+	})
+}
+
+// requeue hands a retry of an asynchronous subscription to its worker, it is subject to the overflow
+// policy like any other delivery
+func (ctx *Broker) requeue(c context.Context, id string, sub *subscriptionCtx, publisher string, recCtx *attributeCtx, rec *ValueRecord, i int, attempt int) {
+	ctx.push(id, sub, delivery{
+		ctx:       c,
+		recCtx:    recCtx,
+		rec:       rec,
+		response:  i,
+		publisher: publisher,
+		attempt:   attempt,
+	})
+}
+
+// deadLetter publishes rec to the dead letter attribute of sub, a dead letter that cannot be delivered
+// is only logged so a catch-all subscription cannot feed on its own dead letters
+func (ctx *Broker) deadLetter(c context.Context, id string, sub *subscriptionCtx, recCtx *attributeCtx, rec *ValueRecord, i int) {
+	var res SubscriptionResponse
+	recCtx.updateResponse(rec, i, func(r *SubscriptionResponse) {
+		res = *r
+	})
+	ctx.log().Printf("dead letter subscription:'%s' attribute:'%s' value:'%s' attempts: %d", id, rec.AttributeID, rec.Value.Inspect(), res.Attempts)
+	if sub.Retry.DeadLetter == "" || sub.Retry.DeadLetter == rec.AttributeID {
+		return
+	}
+	errs := make([]interface{}, 0, len(res.Err))
+	for _, err := range res.Err {
+		errs = append(errs, err.Error())
+	}
+	dl := map[string]interface{}{
+		"subscription": id,
+		"attribute":    rec.AttributeID,
+		"record":       rec.RecordId,
+		"value":        rec.Value.Inspect(),
+		"errors":       errs,
+		"attempts":     res.Attempts,
+	}
+	if err := ctx.publish(detachedContext{parent: c}, id, sub.Retry.DeadLetter, dl, rec, nil); err != nil {
+		return
+	}
+	recCtx.updateResponse(rec, i, func(r *SubscriptionResponse) {
+		r.DeadLettered = true
+	})
+}
@@ -0,0 +1,253 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// settledResponse waits for the response of sub on the latest value of attr to settle
+func settledResponse(t *testing.T, broker *Broker, attr string, sub string) SubscriptionResponse {
+	deadline := time.Now().Add(time.Second)
+	for {
+		rec, err := broker.Value(attr, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, res := range rec.SubscriptionResponses {
+			if res.SubscriptionID == sub && !res.Pending && !res.Retrying {
+				return res
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no settled response from %s on %s: %+v", sub, attr, rec.SubscriptionResponses)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	tests := []struct {
+		Policy   RetryPolicy
+		Expected []time.Duration
+	}{
+		{RetryPolicy{Backoff: 10 * time.Millisecond}, []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond}},
+		{RetryPolicy{Backoff: 10 * time.Millisecond, Multiplier: 3}, []time.Duration{10 * time.Millisecond, 30 * time.Millisecond, 90 * time.Millisecond}},
+		{RetryPolicy{Backoff: 10 * time.Millisecond, MaxBackoff: 25 * time.Millisecond}, []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 25 * time.Millisecond}},
+		{RetryPolicy{Backoff: time.Second, Multiplier: 1}, []time.Duration{time.Second, time.Second, time.Second}},
+	}
+	for _, test := range tests {
+		var got []time.Duration
+		for attempt := 1; attempt <= len(test.Expected); attempt++ {
+			got = append(got, test.Policy.backoff(attempt))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(test.Expected) {
+			t.Errorf("%+v expected %v got %v", test.Policy, test.Expected, got)
+		}
+	}
+
+	for _, invalid := range []RetryPolicy{{MaxAttempts: -1}, {Backoff: -time.Second}, {Multiplier: 0.5}, {DeadLetter: "ops.*"}} {
+		broker := &Broker{}
+		n := BasicNode{ID: "n", Subscriptions: []Subscription{{Name: "s", Filter: ">", Retry: invalid, Fn: func(ctx Context, v Value) {}}}}
+		if err := broker.Register(n); err == nil {
+			t.Errorf("%+v expected an error", invalid)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	for _, async := range []bool{false, true} {
+		t.Run(fmt.Sprintf("async=%t", async), func(t *testing.T) {
+			broker := &Broker{}
+			sensor := BasicNode{ID: "sensor", Attributes: []Attribute{{Name: "v", Definition: IntegerDefinition{}}}}
+			if err := broker.Register(sensor); err != nil {
+				t.Fatal(err)
+			}
+
+			var lock sync.Mutex
+			calls := make(map[string]int)
+			offline := errors.New("device offline")
+			automation := BasicNode{ID: "automation", Subscriptions: []Subscription{{
+				Name:     "flaky",
+				Filter:   "sensor.v",
+				Delivery: Delivery{Async: async},
+				Retry:    RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond},
+				Fn: func(ctx Context, v Value) {
+					lock.Lock()
+					defer lock.Unlock()
+					calls[v.Inspect()]++
+					// 1 recovers on the last attempt, 2 never does
+					if v.Inspect() == "2" || calls[v.Inspect()] < 3 {
+						ctx.Error(offline)
+					}
+				},
+			}}}
+			if err := broker.Register(automation); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := broker.Publish(sensor, "sensor.v", 1); err != nil {
+				t.Fatal(err)
+			}
+			res := settledResponse(t, broker, "sensor.v", "automation@flaky")
+			if res.Attempts != 3 || len(res.Err) != 2 || res.DeadLettered {
+				t.Errorf("expected a success on the third attempt got %+v", res)
+			}
+
+			if err := broker.Publish(sensor, "sensor.v", 2); err != nil {
+				t.Fatal(err)
+			}
+			res = settledResponse(t, broker, "sensor.v", "automation@flaky")
+			if res.Attempts != 3 || len(res.Err) != 3 || !errors.Is(res.Err[2], offline) {
+				t.Errorf("expected three failed attempts got %+v", res)
+			}
+			// without a dead letter attribute the value is only logged
+			if res.DeadLettered {
+				t.Errorf("expected no dead letter got %+v", res)
+			}
+		})
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	broker := &Broker{}
+	sensor := BasicNode{ID: "sensor", Attributes: []Attribute{{Name: "v", Definition: IntegerDefinition{}}}}
+	ops := BasicNode{ID: "ops", Attributes: []Attribute{{Name: "deadletter", Definition: DeadLetterDefinition()}}}
+	for _, n := range []BasicNode{sensor, ops} {
+		if err := broker.Register(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	alerts := make(chan DeadLetter, 4)
+	watcher := BasicNode{ID: "watcher", Subscriptions: []Subscription{{
+		Name:   "alerts",
+		Filter: "ops.deadletter",
+		Fn: func(ctx Context, v Value) {
+			if dl, err := DeadLetterOf(v); err == nil && dl.Subscription != "" {
+				alerts <- dl
+			}
+		},
+	}}}
+	// the catch-all also sees its own dead letters, which must not be dead lettered again
+	automation := BasicNode{ID: "automation", Subscriptions: []Subscription{{
+		Name:   "broken",
+		Filter: "> except watcher.>",
+		Retry:  RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond, DeadLetter: "ops.deadletter"},
+		Fn: func(ctx Context, v Value) {
+			ctx.Error(errors.New("broken"))
+		},
+	}}}
+	for _, n := range []BasicNode{watcher, automation} {
+		if err := broker.Register(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := broker.Publish(sensor, "sensor.v", 42); err != nil {
+		t.Fatal(err)
+	}
+	var dl DeadLetter
+	select {
+	case dl = <-alerts:
+	case <-time.After(time.Second):
+		t.Fatal("expected a dead letter")
+	}
+	rec, _ := broker.Value("sensor.v", time.Now())
+	if dl.Subscription != "automation@broken" || dl.Record != rec.Ref() || dl.Value != "42" || dl.Attempts != 2 || len(dl.Errors) != 2 {
+		t.Errorf("unexpected dead letter %+v", dl)
+	}
+	// the response is marked once the dead letter was published
+	deadline := time.Now().Add(time.Second)
+	for res := settledResponse(t, broker, "sensor.v", "automation@broken"); !res.DeadLettered; res = settledResponse(t, broker, "sensor.v", "automation@broken") {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the response to be dead lettered got %+v", res)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	deadLetters := broker.History("ops.deadletter", time.Time{}, time.Now().Add(time.Second))
+	chain, err := broker.CausalChain(deadLetters[len(deadLetters)-1].Ref())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 2 || chain[1].Ref() != rec.Ref() {
+		t.Errorf("expected the dead letter to be caused by the failed value got %+v", chain)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	select {
+	case dl := <-alerts:
+		t.Errorf("expected a single dead letter got another %+v", dl)
+	default:
+	}
+}
+
+func TestRetryAsyncOneAtATime(t *testing.T) {
+	broker := &Broker{}
+	sensor := BasicNode{ID: "sensor", Attributes: []Attribute{{Name: "v", Definition: IntegerDefinition{}}}}
+	if err := broker.Register(sensor); err != nil {
+		t.Fatal(err)
+	}
+	var lock sync.Mutex
+	running, most := 0, 0
+	automation := BasicNode{ID: "automation", Subscriptions: []Subscription{{
+		Name:     "slow",
+		Filter:   "sensor.v",
+		Delivery: Delivery{Async: true, Overflow: OverflowBlock},
+		Retry:    RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond},
+		Fn: func(ctx Context, v Value) {
+			lock.Lock()
+			running++
+			if running > most {
+				most = running
+			}
+			lock.Unlock()
+			time.Sleep(2 * time.Millisecond)
+			lock.Lock()
+			running--
+			lock.Unlock()
+			ctx.Error(errors.New("busy"))
+		},
+	}}}
+	if err := broker.Register(automation); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 5; i++ {
+		if err := broker.Publish(sensor, "sensor.v", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, rec := range broker.History("sensor.v", time.Time{}, time.Now().Add(time.Second)) {
+		if rec.Value.Value == int64(0) {
+			continue
+		}
+		// settledResponse only looks at the latest value, so wait on each record here
+		deadline := time.Now().Add(time.Second)
+		for {
+			var res []SubscriptionResponse
+			for _, r := range broker.History("sensor.v", time.Time{}, time.Now().Add(time.Second)) {
+				if r.RecordId == rec.RecordId {
+					res = r.SubscriptionResponses
+				}
+			}
+			if len(res) == 1 && !res[0].Pending && !res[0].Retrying {
+				if res[0].Attempts != 3 {
+					t.Errorf("record %d expected 3 attempts got %+v", rec.RecordId, res[0])
+				}
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("record %d never settled %+v", rec.RecordId, res)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if most != 1 {
+		t.Errorf("expected the worker to be the only caller of fn got %d at once", most)
+	}
+}
@@ -0,0 +1,313 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Schema types of the built in definitions
+const (
+	TypeString   = "string"
+	TypeBoolean  = "boolean"
+	TypeInteger  = "integer"
+	TypeDouble   = "double"
+	TypeEnum     = "enum"
+	TypeObject   = "object"
+	TypeArray    = "array"
+	TypeTime     = "time"
+	TypeDuration = "duration"
+	TypeBytes    = "bytes"
+)
+
+// AccessMode controls who may publish to an attribute
+type AccessMode int
+
+const (
+	// AccessReadWrite lets any node publish, subject to the Accept of the definition
+	AccessReadWrite AccessMode = iota
+	// AccessReadOnly only lets the owning node publish, anyone else gets ErrReadOnly
+	AccessReadOnly
+)
+
+func (m AccessMode) String() string {
+	switch m {
+	case AccessReadWrite:
+		return "rw"
+	case AccessReadOnly:
+		return "ro"
+	}
+	return "unknown"
+}
+
+func (m AccessMode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *AccessMode) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "rw", "":
+		*m = AccessReadWrite
+	case "ro":
+		*m = AccessReadOnly
+	default:
+		return fmt.Errorf("unknown access mode '%s'", text)
+	}
+	return nil
+}
+
+// Schema is a serializable description of a Definition, see SchemaOf and Schema.Definition
+type Schema struct {
+	Type    string      `json:"type"`
+	Default interface{} `json:"default,omitempty"`
+	// Coercion is empty for lenient scalars
+	Coercion string `json:"coercion,omitempty"`
+
+	// numeric, for a duration Unit is the NumberUnit in Go duration syntax
+	Unit      string   `json:"unit,omitempty"`
+	Min       *float64 `json:"min,omitempty"`
+	Max       *float64 `json:"max,omitempty"`
+	Step      float64  `json:"step,omitempty"`
+	Precision int      `json:"precision,omitempty"`
+	Policy    string   `json:"policy,omitempty"`
+
+	// enum
+	Values []string `json:"values,omitempty"`
+
+	// object
+	Fields []FieldSchema `json:"fields,omitempty"`
+
+	// array, MaxLength is also the MaxSize of bytes
+	Element   *Schema `json:"element,omitempty"`
+	MinLength int     `json:"min_length,omitempty"`
+	MaxLength int     `json:"max_length,omitempty"`
+}
+
+type FieldSchema struct {
+	Name     string `json:"name"`
+	Required bool   `json:"required,omitempty"`
+	Schema   Schema `json:"schema"`
+}
+
+// AttributeSchema describes a registered attribute, as returned by Broker.Describe
+type AttributeSchema struct {
+	AttributeID string     `json:"attr"`
+	Owner       string     `json:"owner"`
+	Access      AccessMode `json:"access"`
+	Schema      Schema     `json:"schema"`
+}
+
+// SchemaDefinition is implemented by custom definitions that can describe themselves,
+// anything else that is not built in is described by its Go type name only
+type SchemaDefinition interface {
+	Schema() Schema
+}
+
+func coercionName(c Coercion) string {
+	if c == CoerceLenient {
+		return ""
+	}
+	return c.String()
+}
+
+func numericSchema(typ string, c Constraints, u Unit, coercion Coercion, def interface{}) Schema {
+	s := Schema{Type: typ, Default: def, Coercion: coercionName(coercion), Unit: u.Symbol, Min: c.Min, Max: c.Max, Step: c.Step, Precision: c.Precision}
+	if c.Policy != RangeReject {
+		s.Policy = c.Policy.String()
+	}
+	return s
+}
+
+// SchemaOf describes d
+func SchemaOf(d Definition) Schema {
+	switch def := d.(type) {
+	case SchemaDefinition:
+		return def.Schema()
+	case StringDefinition:
+		return Schema{Type: TypeString, Default: def.DefaultValue(), Coercion: coercionName(def.Coercion)}
+	case BooleanDefinition:
+		return Schema{Type: TypeBoolean, Default: def.DefaultValue(), Coercion: coercionName(def.Coercion)}
+	case IntegerDefinition:
+		return numericSchema(TypeInteger, def.Constraints, def.Unit, def.Coercion, def.DefaultValue())
+	case DoubleDefinition:
+		return numericSchema(TypeDouble, def.Constraints, def.Unit, def.Coercion, def.DefaultValue())
+	case TimeDefinition:
+		return Schema{Type: TypeTime, Default: def.DefaultValue(), Coercion: coercionName(def.Coercion)}
+	case DurationDefinition:
+		s := Schema{Type: TypeDuration, Default: def.EncodeValue(def.DefaultValue()), Coercion: coercionName(def.Coercion)}
+		if def.NumberUnit > 0 {
+			s.Unit = def.NumberUnit.String()
+		}
+		return s
+	case BytesDefinition:
+		return Schema{Type: TypeBytes, Default: def.DefaultValue(), Coercion: coercionName(def.Coercion), MaxLength: def.MaxSize}
+	case EnumDefinition:
+		return Schema{Type: TypeEnum, Default: def.DefaultValue(), Values: def.Options()}
+	case ObjectDefinition:
+		s := Schema{Type: TypeObject, Default: def.EncodeValue(def.DefaultValue())}
+		for _, f := range def.Fields {
+			s.Fields = append(s.Fields, FieldSchema{Name: f.Name, Required: f.Required, Schema: SchemaOf(f.Definition)})
+		}
+		return s
+	case ArrayDefinition:
+		element := SchemaOf(def.Element)
+		return Schema{Type: TypeArray, Default: def.EncodeValue(def.DefaultValue()), Element: &element, MinLength: def.MinLength, MaxLength: def.MaxLength}
+	}
+	if d == nil {
+		return Schema{}
+	}
+	return Schema{Type: reflect.TypeOf(d).Name(), Default: d.DefaultValue()}
+}
+
+func (s Schema) coercion() (Coercion, error) {
+	switch s.Coercion {
+	case "", CoerceLenient.String():
+		return CoerceLenient, nil
+	case CoerceStrict.String():
+		return CoerceStrict, nil
+	}
+	return CoerceLenient, fmt.Errorf("unknown coercion '%s'", s.Coercion)
+}
+
+func (s Schema) constraints() (Constraints, Unit, error) {
+	c := Constraints{Min: s.Min, Max: s.Max, Step: s.Step, Precision: s.Precision}
+	switch s.Policy {
+	case "", RangeReject.String():
+	case RangeClamp.String():
+		c.Policy = RangeClamp
+	default:
+		return c, Unit{}, fmt.Errorf("unknown range policy '%s'", s.Policy)
+	}
+	if s.Unit == "" {
+		return c, Unit{}, nil
+	}
+	u, ok := LookupUnit(s.Unit)
+	if !ok {
+		return c, Unit{}, ErrUnknownUnit{Symbol: s.Unit}
+	}
+	return c, u, nil
+}
+
+// Definition builds the definition s describes. acceptFn, when not nil, becomes the AcceptContextFn
+// of the outermost definition and is handed the transformed value
+func (s Schema) Definition(acceptFn func(ctx context.Context, v interface{}) error) (Definition, error) {
+	coercion, err := s.coercion()
+	if err != nil {
+		return nil, err
+	}
+	switch s.Type {
+	case TypeString:
+		d := StringDefinition{Coercion: coercion}
+		if acceptFn != nil {
+			d.AcceptContextFn = func(ctx context.Context, v string) error { return acceptFn(ctx, v) }
+		}
+		return d, nil
+	case TypeBoolean:
+		d := BooleanDefinition{Coercion: coercion}
+		if acceptFn != nil {
+			d.AcceptContextFn = func(ctx context.Context, v bool) error { return acceptFn(ctx, v) }
+		}
+		return d, nil
+	case TypeInteger:
+		c, u, err := s.constraints()
+		if err != nil {
+			return nil, err
+		}
+		d := IntegerDefinition{Constraints: c, Unit: u, Coercion: coercion}
+		if acceptFn != nil {
+			d.AcceptContextFn = func(ctx context.Context, v int64) error { return acceptFn(ctx, v) }
+		}
+		return d, nil
+	case TypeDouble:
+		c, u, err := s.constraints()
+		if err != nil {
+			return nil, err
+		}
+		d := DoubleDefinition{Constraints: c, Unit: u, Coercion: coercion}
+		if acceptFn != nil {
+			d.AcceptContextFn = func(ctx context.Context, v float64) error { return acceptFn(ctx, v) }
+		}
+		return d, nil
+	case TypeTime:
+		d := TimeDefinition{Coercion: coercion}
+		if acceptFn != nil {
+			d.AcceptContextFn = func(ctx context.Context, v time.Time) error { return acceptFn(ctx, v) }
+		}
+		return d, nil
+	case TypeDuration:
+		d := DurationDefinition{Coercion: coercion}
+		if s.Unit != "" {
+			if d.NumberUnit, err = time.ParseDuration(s.Unit); err != nil {
+				return nil, err
+			}
+		}
+		if acceptFn != nil {
+			d.AcceptContextFn = func(ctx context.Context, v time.Duration) error { return acceptFn(ctx, v) }
+		}
+		return d, nil
+	case TypeBytes:
+		d := BytesDefinition{Coercion: coercion, MaxSize: s.MaxLength}
+		if acceptFn != nil {
+			d.AcceptContextFn = func(ctx context.Context, v []byte) error { return acceptFn(ctx, v) }
+		}
+		return d, nil
+	case TypeEnum:
+		if len(s.Values) == 0 {
+			return nil, fmt.Errorf("enum schema without values")
+		}
+		d := EnumDefinition{Values: append([]string(nil), s.Values...)}
+		if s.Default != nil {
+			def, ok := s.Default.(string)
+			if _, err := d.ValidateAndTransform(def); !ok || err != nil {
+				return nil, ErrInvalidEnumValue{Value: s.Default, Options: d.Options()}
+			}
+			d.Default = def
+		}
+		if acceptFn != nil {
+			d.AcceptContextFn = func(ctx context.Context, v string) error { return acceptFn(ctx, v) }
+		}
+		return d, nil
+	case TypeObject:
+		d := ObjectDefinition{}
+		for _, f := range s.Fields {
+			fd, err := f.Schema.Definition(nil)
+			if err != nil {
+				return nil, ErrInvalidField{Field: f.Name, Err: err}
+			}
+			d.Fields = append(d.Fields, Field{Name: f.Name, Definition: fd, Required: f.Required})
+		}
+		if acceptFn != nil {
+			d.AcceptContextFn = func(ctx context.Context, v map[string]interface{}) error { return acceptFn(ctx, v) }
+		}
+		return d, nil
+	case TypeArray:
+		if s.Element == nil {
+			return nil, fmt.Errorf("array schema without element")
+		}
+		element, err := s.Element.Definition(nil)
+		if err != nil {
+			return nil, err
+		}
+		d := ArrayDefinition{Element: element, MinLength: s.MinLength, MaxLength: s.MaxLength}
+		if acceptFn != nil {
+			d.AcceptContextFn = func(ctx context.Context, v []interface{}) error { return acceptFn(ctx, v) }
+		}
+		return d, nil
+	}
+	return nil, ErrUnknownSchemaType{Type: s.Type}
+}
+
+// Describe returns the schema of every attribute matching filter ordered by id
+func (ctx *Broker) Describe(filter string) []AttributeSchema {
+	var schemas []AttributeSchema
+	for _, a := range ctx.matching(filter) {
+		schemas = append(schemas, AttributeSchema{
+			AttributeID: a.id,
+			Owner:       a.node,
+			Access:      a.Attribute.Access,
+			Schema:      SchemaOf(a.Attribute.Definition),
+		})
+	}
+	return schemas
+}
@@ -0,0 +1,121 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestSchemaRoundTrip(t *testing.T) {
+	defs := []Definition{
+		StringDefinition{},
+		BooleanDefinition{},
+		IntegerDefinition{Constraints: Constraints{Min: Bound(0), Max: Bound(10), Step: 2}},
+		DoubleDefinition{Constraints: Constraints{Min: Bound(10), Max: Bound(30), Precision: 1, Policy: RangeClamp}, Unit: Celsius},
+		EnumDefinition{Values: []string{"off", "heat", "cool"}, Default: "heat"},
+		ObjectDefinition{Fields: []Field{
+			{Name: "r", Definition: IntegerDefinition{}, Required: true},
+			{Name: "label", Definition: StringDefinition{}},
+		}},
+		ArrayDefinition{Element: EnumDefinition{Values: []string{"a", "b"}}, MinLength: 1, MaxLength: 4},
+	}
+	for i, def := range defs {
+		b, err := json.Marshal(SchemaOf(def))
+		if err != nil {
+			t.Fatalf("%d %s", i, err)
+		}
+		var s Schema
+		if err := json.Unmarshal(b, &s); err != nil {
+			t.Fatalf("%d %s", i, err)
+		}
+		built, err := s.Definition(nil)
+		if err != nil {
+			t.Errorf("%d %s unexpected error %s", i, b, err)
+			continue
+		}
+		rebuilt, _ := json.Marshal(SchemaOf(built))
+		if string(rebuilt) != string(b) {
+			t.Errorf("%d expected %s got %s", i, b, rebuilt)
+		}
+	}
+
+	invalid := []Schema{
+		{Type: "color"},
+		{Type: TypeDouble, Unit: "furlong"},
+		{Type: TypeDouble, Policy: "wrap"},
+		{Type: TypeEnum},
+		{Type: TypeEnum, Values: []string{"a"}, Default: "b"},
+		{Type: TypeArray},
+		{Type: TypeObject, Fields: []FieldSchema{{Name: "x", Schema: Schema{Type: "color"}}}},
+	}
+	for i, s := range invalid {
+		if d, err := s.Definition(nil); err == nil {
+			t.Errorf("%d expected %+v to be rejected got %#v", i, s, d)
+		}
+	}
+}
+
+func TestSchemaAccept(t *testing.T) {
+	var accepted []interface{}
+	d, err := Schema{Type: TypeDouble}.Definition(func(ctx context.Context, v interface{}) error {
+		accepted = append(accepted, v)
+		if v.(float64) < 0 {
+			return errors.New("negative")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Accept(1.5); err != nil {
+		t.Error(err)
+	}
+	if err := d.Accept(-1.0); err == nil {
+		t.Error("expected the accept function to reject a negative value")
+	}
+	if len(accepted) != 2 {
+		t.Errorf("expected the accept function to run twice got %v", accepted)
+	}
+}
+
+func TestBrokerDescribe(t *testing.T) {
+	broker := &Broker{}
+	sensor := BasicNode{ID: "sensor", Attributes: []Attribute{
+		{Name: "temperature", Definition: DoubleDefinition{Unit: Celsius}, Access: AccessReadOnly},
+		{Name: "label", Definition: StringDefinition{}},
+	}}
+	app := BasicNode{ID: "app"}
+	if err := broker.Register(sensor); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.Register(app); err != nil {
+		t.Fatal(err)
+	}
+
+	schemas := broker.Describe("sensor.>")
+	if len(schemas) != 2 {
+		t.Fatalf("expected 2 schemas got %+v", schemas)
+	}
+	if s := schemas[1]; s.AttributeID != "sensor.temperature" || s.Owner != "sensor" || s.Access != AccessReadOnly ||
+		s.Schema.Type != TypeDouble || s.Schema.Unit != "°C" {
+		t.Errorf("unexpected schema %+v", s)
+	}
+	b, err := json.Marshal(schemas[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"attr":"sensor.label","owner":"sensor","access":"rw","schema":{"type":"string","default":""}}` {
+		t.Errorf("unexpected json %s", b)
+	}
+
+	if err := broker.Publish(app, "sensor.temperature", 20.0); !errors.As(err, new(ErrReadOnly)) {
+		t.Errorf("expected ErrReadOnly got %v", err)
+	}
+	if err := broker.Publish(sensor, "sensor.temperature", 20.0); err != nil {
+		t.Errorf("expected the owner to publish to a read only attribute got %v", err)
+	}
+	if err := broker.Publish(app, "sensor.label", "kitchen"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,88 @@
+package pubsub
+
+import "context"
+
+type heldDelivery struct {
+	c         context.Context
+	publisher string
+	recCtx    *attributeCtx
+	rec       *ValueRecord
+}
+
+type snapshotEntry struct {
+	recCtx *attributeCtx
+	rec    *ValueRecord
+}
+
+// delivered reports if rec is older than or the same as the record the snapshot delivered
+func (sub *subscriptionCtx) delivered(recCtx *attributeCtx, rec *ValueRecord) bool {
+	id, ok := sub.snapshot[recCtx]
+	return ok && rec.RecordId <= id
+}
+
+// hold keeps a live delivery aside while the snapshot is being delivered
+func (sub *subscriptionCtx) hold(c context.Context, publisher string, recCtx *attributeCtx, rec *ValueRecord) bool {
+	sub.lock.Lock()
+	defer sub.lock.Unlock()
+	if !sub.snapshotting {
+		return false
+	}
+	sub.held = append(sub.held, heldDelivery{c: c, publisher: publisher, recCtx: recCtx, rec: rec})
+	return true
+}
+
+// nextHeld pops the oldest held delivery, once there is none left live deliveries stop being held
+func (sub *subscriptionCtx) nextHeld() (heldDelivery, bool) {
+	sub.lock.Lock()
+	defer sub.lock.Unlock()
+	if len(sub.held) == 0 {
+		sub.snapshotting = false
+		sub.held = nil
+		return heldDelivery{}, false
+	}
+	d := sub.held[0]
+	sub.held = sub.held[1:]
+	return d, true
+}
+
+// takeSnapshot collects the newest record of every attribute matching the filter of sub and starts
+// holding live deliveries, it must be called with the broker lock held before sub is indexed
+func (ctx *Broker) takeSnapshot(sub *subscriptionCtx) []snapshotEntry {
+	var entries []snapshotEntry
+	sub.snapshot = make(map[*attributeCtx]int)
+	for _, id := range ctx.attributeIndex.matchKeys(sub.Filter) {
+		recCtx := ctx.attributes[id]
+		recCtx.lock.RLock()
+		var rec *ValueRecord
+		if len(recCtx.Records) > 0 {
+			rec = recCtx.Records[len(recCtx.Records)-1]
+			sub.snapshot[recCtx] = rec.RecordId
+		}
+		recCtx.lock.RUnlock()
+		// the condition sees the snapshot first so its hysteresis starts from the current value
+		if rec != nil && sub.holds(recCtx, rec) {
+			entries = append(entries, snapshotEntry{recCtx: recCtx, rec: rec})
+		}
+	}
+	sub.snapshotting = true
+	return entries
+}
+
+// deliverSnapshot delivers the snapshot and then the live values held meanwhile
+func (ctx *Broker) deliverSnapshot(id string, sub *subscriptionCtx, entries []snapshotEntry) {
+	ctx.log().Printf("snapshot subscription:'%s' filter: '%s' values: %d", id, sub.Filter, len(entries))
+	for _, e := range entries {
+		if sub.active() {
+			ctx.deliver(context.Background(), id, sub, e.rec.UpdatedBy, e.recCtx, e.rec, true)
+		}
+	}
+	for {
+		d, ok := sub.nextHeld()
+		if !ok {
+			return
+		}
+		if sub.active() {
+			ctx.dispatch(d.c, id, sub, d.publisher, d.recCtx, d.rec)
+		}
+	}
+}
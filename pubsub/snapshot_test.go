@@ -0,0 +1,135 @@
+package pubsub
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSubscriptionSnapshot(t *testing.T) {
+	broker := &Broker{}
+	sensor := BasicNode{ID: "sensor", Attributes: []Attribute{
+		{Name: "b", Definition: StringDefinition{}},
+		{Name: "a", Definition: StringDefinition{}},
+		{Name: "c", Definition: StringDefinition{}},
+	}}
+	if err := broker.Register(sensor); err != nil {
+		t.Fatal(err)
+	}
+	for _, attr := range []string{"sensor.a", "sensor.b"} {
+		if err := broker.Publish(sensor, attr, "v1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	dashboard := BasicNode{ID: "dashboard", Subscriptions: []Subscription{{
+		Name:     "all",
+		Filter:   "sensor.*",
+		Snapshot: true,
+		Fn: func(ctx Context, v Value) {
+			got = append(got, v.AttributeID+"="+v.Inspect())
+		},
+	}}}
+	if err := broker.Register(dashboard); err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprint([]string{"sensor.a=v1", "sensor.b=v1", "sensor.c="})
+	if fmt.Sprint(got) != expected {
+		t.Errorf("expected %s got %v", expected, got)
+	}
+	rec, _ := broker.Value("sensor.a", time.Now())
+	if len(rec.SubscriptionResponses) != 1 || !rec.SubscriptionResponses[0].Snapshot {
+		t.Errorf("expected the snapshot to be recorded got %+v", rec.SubscriptionResponses)
+	}
+
+	if err := broker.Publish(sensor, "sensor.c", "v2"); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 4 || got[3] != "sensor.c=v2" {
+		t.Errorf("expected live values after the snapshot got %v", got)
+	}
+}
+
+func TestSubscriptionSnapshotConcurrent(t *testing.T) {
+	for _, async := range []bool{false, true} {
+		t.Run(fmt.Sprintf("async=%t", async), func(t *testing.T) {
+			broker := &Broker{}
+			const attrs, publishes = 4, 200
+			var nodes []BasicNode
+			for i := 0; i < attrs; i++ {
+				n := BasicNode{ID: fmt.Sprintf("n%d", i), Attributes: []Attribute{{Name: "v", Definition: DoubleDefinition{}}}}
+				if err := broker.Register(n); err != nil {
+					t.Fatal(err)
+				}
+				nodes = append(nodes, n)
+			}
+
+			var lock sync.Mutex
+			seen := make(map[string][]float64)
+			var wg sync.WaitGroup
+			for _, n := range nodes {
+				wg.Add(1)
+				go func(n BasicNode) {
+					defer wg.Done()
+					for i := 1; i <= publishes; i++ {
+						if err := broker.Publish(n, n.ID+".v", i); err != nil {
+							t.Error(err)
+						}
+					}
+				}(n)
+			}
+			time.Sleep(time.Millisecond)
+			sub := Subscription{
+				Name:     "watch",
+				Filter:   "*.v",
+				Snapshot: true,
+				Delivery: Delivery{Async: async, Queue: attrs * publishes},
+				Fn: func(ctx Context, v Value) {
+					lock.Lock()
+					seen[v.AttributeID] = append(seen[v.AttributeID], v.Value.(float64))
+					lock.Unlock()
+				},
+			}
+			if err := broker.Subscribe(BasicNode{ID: "watcher"}, sub); err != nil {
+				t.Fatal(err)
+			}
+			wg.Wait()
+
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				lock.Lock()
+				done := true
+				for _, n := range nodes {
+					vs := seen[n.ID+".v"]
+					if len(vs) == 0 || vs[len(vs)-1] != publishes {
+						done = false
+					}
+				}
+				lock.Unlock()
+				if done || time.Now().After(deadline) {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			for _, n := range nodes {
+				vs := seen[n.ID+".v"]
+				if len(vs) == 0 {
+					t.Fatalf("%s nothing delivered", n.ID)
+				}
+				for i := 1; i < len(vs); i++ {
+					if vs[i] != vs[i-1]+1 {
+						t.Fatalf("%s expected no gap or duplicate got %v after %v", n.ID, vs[i], vs[i-1])
+					}
+				}
+				if vs[len(vs)-1] != publishes {
+					t.Errorf("%s expected the stream to end at %d got %v", n.ID, publishes, vs[len(vs)-1])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package pubsub
+
+// Store persists the history of every attribute so it survives a restart of the broker.
+// Subscription responses are not persisted, only the published values
+type Store interface {
+	// Load returns the persisted records of attr oldest first
+	Load(attr string) ([]ValueRecord, error)
+	// Append persists a newly published record
+	Append(rec ValueRecord) error
+	// Drop forgets records of attr that were discarded by its RetentionPolicy
+	Drop(attr string, recordIds []int) error
+}
+
+// EncodingDefinition is implemented by definitions whose values do not survive the JSON encoding of a Store
+// as they are. Records are handed to the store with the encoded value, which ValidateAndTransform turns back on replay
+type EncodingDefinition interface {
+	EncodeValue(v interface{}) interface{}
+}
+
+func encodeValue(d Definition, v interface{}) interface{} {
+	if e, ok := d.(EncodingDefinition); ok {
+		return e.EncodeValue(v)
+	}
+	return v
+}
+
+func dropRecords(recs []ValueRecord, recordIds []int) []ValueRecord {
+	drop := make(map[int]bool, len(recordIds))
+	for _, id := range recordIds {
+		drop[id] = true
+	}
+	kept := recs[:0]
+	for _, rec := range recs {
+		if !drop[rec.RecordId] {
+			kept = append(kept, rec)
+		}
+	}
+	return kept
+}
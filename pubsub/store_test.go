@@ -0,0 +1,325 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStoreReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pubsub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "broker.log")
+
+	var accepted int
+	node := func() BasicNode {
+		return BasicNode{
+			ID: "n1",
+			Attributes: []Attribute{
+				{Name: "d", Definition: DoubleDefinition{AcceptFn: func(v float64) error {
+					accepted++
+					return nil
+				}}},
+				{Name: "b", Definition: BooleanDefinition{}},
+				{Name: "s", Definition: StringDefinition{}},
+				{Name: "t", Definition: TimeDefinition{Coercion: CoerceStrict}},
+				{Name: "dur", Definition: DurationDefinition{Coercion: CoerceStrict}},
+				{Name: "blob", Definition: BytesDefinition{Coercion: CoerceStrict}},
+				{Name: "ir", Definition: BytesDefinition{}},
+				{Name: "twice", Definition: DoubleDefinition{}, Derive: Derivation{
+					Inputs: []string{"n1.d"},
+					Fn: func(inputs map[string]Value) (interface{}, error) {
+						return inputs["n1.d"].Value.(float64) * 2, nil
+					},
+				}},
+			},
+		}
+	}
+	other := BasicNode{ID: "other"}
+
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker := &Broker{Store: store}
+	if err := broker.Register(node()); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []struct {
+		attr  string
+		value interface{}
+	}{
+		{"n1.d", 42},
+		{"n1.b", true},
+		{"n1.s", "hello"},
+		{"n1.t", time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)},
+		{"n1.dur", 1500 * time.Millisecond},
+		{"n1.blob", []byte{0, 1, 254, 255}},
+		// base64 that reads like hex
+		{"n1.ir", []byte{0xd3, 0x10, 0x20}},
+	} {
+		if err := broker.Publish(other, p.attr, p.value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	twice, _ := broker.Value("n1.twice", time.Now())
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	accepted = 0
+
+	store, err = OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	// derived records keep the records they were computed from
+	if recs, _ := store.Load("n1.twice"); len(recs) == 0 || fmt.Sprint(recs[len(recs)-1].Inputs) != fmt.Sprint(twice.Inputs) || len(twice.Inputs) != 1 {
+		t.Errorf("expected the inputs %v to be replayed got %+v", twice.Inputs, recs)
+	}
+	broker = &Broker{Store: store}
+	if err := broker.Register(node()); err != nil {
+		t.Fatal(err)
+	}
+	if accepted != 0 {
+		t.Errorf("expected replay to skip accept got %d calls", accepted)
+	}
+
+	for attr, expected := range map[string]interface{}{
+		"n1.d": 42.0,
+		"n1.b": true,
+		"n1.s": "hello",
+	} {
+		rec, err := broker.Value(attr, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Value.Value != expected {
+			t.Errorf("%s expected %v (%T) got %v (%T)", attr, expected, expected, rec.Value.Value, rec.Value.Value)
+		}
+		if rec.RecordId != 1 {
+			t.Errorf("%s expected record id 1 got %d", attr, rec.RecordId)
+		}
+	}
+
+	// values that do not survive json as they are come back through their definitions
+	for attr, expected := range map[string]string{
+		"n1.t":    "2020-01-02T03:04:05.000000006Z",
+		"n1.dur":  "1.5s",
+		"n1.blob": "AAH+/w==",
+		"n1.ir":   "0xAg",
+	} {
+		rec, err := broker.Value(attr, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Value.Inspect() != expected {
+			t.Errorf("%s expected %s got %s (%T)", attr, expected, rec.Value.Inspect(), rec.Value.Value)
+		}
+	}
+
+	if err := broker.Publish(other, "n1.s", "again"); err != nil {
+		t.Fatal(err)
+	}
+	if rec, _ := broker.Value("n1.s", time.Now().Add(time.Second)); rec.RecordId != 2 {
+		t.Errorf("expected record ids to continue after replay got %d", rec.RecordId)
+	}
+}
+
+func TestFileStoreCompaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pubsub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "broker.log")
+
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker := &Broker{Store: store}
+	n1 := BasicNode{
+		ID: "n1",
+		Attributes: []Attribute{
+			{Name: "a1", Definition: DoubleDefinition{}, Retention: RetentionPolicy{MaxRecords: 10}},
+		},
+	}
+	if err := broker.Register(n1); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3*compactMinRecords; i++ {
+		if err := broker.Publish(n1, "n1.a1", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if store.written > 2*compactMinRecords {
+		t.Errorf("expected the log to be compacted, %d records written", store.written)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	recs, err := store.Load("n1.a1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) > compactMinRecords+10 {
+		t.Errorf("expected at most %d records after compaction got %d", compactMinRecords+10, len(recs))
+	}
+	if last := recs[len(recs)-1]; last.Value.Value != float64(3*compactMinRecords-1) {
+		t.Errorf("expected the newest record to survive compaction got %v", last.Value.Value)
+	}
+}
+
+func TestFileStoreTornWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pubsub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "broker.log")
+
+	open := func() *FileStore {
+		store, err := OpenFileStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	}
+	store := open()
+	for i := 1; i <= 2; i++ {
+		if err := store.Append(ValueRecord{RecordId: i, Value: Value{AttributeID: "n1.a1", Value: float64(i)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Close()
+
+	// a crash halfway through writing the third record
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"id":3,"attr":"n1.a1","val`)
+	f.Close()
+
+	store = open()
+	if recs, _ := store.Load("n1.a1"); len(recs) != 2 {
+		t.Errorf("expected the torn record to be dropped got %d records", len(recs))
+	}
+	if err := store.Append(ValueRecord{RecordId: 3, Value: Value{AttributeID: "n1.a1", Value: 3.0}}); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store = open()
+	recs, _ := store.Load("n1.a1")
+	if len(recs) != 3 || recs[2].Value.Value != 3.0 {
+		t.Errorf("expected the log to go on after the torn record got %+v", recs)
+	}
+	store.Close()
+
+	// only the last line can be torn, a bad line before it is corruption
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, append([]byte("{bad\n"), b...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenFileStore(path); err == nil {
+		t.Error("expected a bad line before the last one to fail the open")
+	}
+}
+
+func TestMemoryStoreReregister(t *testing.T) {
+	broker := &Broker{Store: &MemoryStore{}}
+	n1 := BasicNode{
+		ID: "n1",
+		Attributes: []Attribute{
+			{Name: "a1", Definition: StringDefinition{}},
+		},
+	}
+	if err := broker.Register(n1); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.Publish(n1, "n1.a1", "kept"); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.Unregister(n1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := broker.Value("n1.a1", time.Now()); !errors.As(err, &ErrUnknownAttribute{}) {
+		t.Errorf("expected ErrUnknownAttribute got %v", err)
+	}
+	if err := broker.Register(n1); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := broker.Value("n1.a1", time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Inspect() != "kept" {
+		t.Errorf("expected 'kept' got '%s'", rec.Inspect())
+	}
+}
+
+// gatedStore holds Load until released
+type gatedStore struct {
+	MemoryStore
+	loading chan struct{}
+	release chan struct{}
+}
+
+func (s *gatedStore) Load(attr string) ([]ValueRecord, error) {
+	s.loading <- struct{}{}
+	<-s.release
+	return s.MemoryStore.Load(attr)
+}
+
+func TestStoreReplayBeforeVisible(t *testing.T) {
+	store := &gatedStore{loading: make(chan struct{}), release: make(chan struct{})}
+	for i := 0; i < 2; i++ {
+		store.Append(ValueRecord{RecordId: i, Value: Value{AttributeID: "n1.a1", Value: float64(i), UpdatedAt: time.Now()}})
+	}
+	broker := &Broker{Store: store}
+	n1 := BasicNode{ID: "n1", Attributes: []Attribute{{Name: "a1", Definition: DoubleDefinition{}}}}
+
+	registered := make(chan error)
+	go func() {
+		registered <- broker.Register(n1)
+	}()
+	<-store.loading
+	if err := broker.Publish(n1, "n1.a1", 42); !errors.As(err, &ErrUnknownAttribute{}) {
+		t.Errorf("expected the attribute to be unknown while its history loads got %v", err)
+	}
+	close(store.release)
+	if err := <-registered; err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.Publish(n1, "n1.a1", 42); err != nil {
+		t.Fatal(err)
+	}
+
+	recs := broker.History("n1.a1", time.Time{}, time.Now().Add(time.Second))
+	for i, rec := range recs {
+		if rec.RecordId != i {
+			t.Errorf("expected record ids to follow the replayed ones got %d at %d", rec.RecordId, i)
+		}
+	}
+	if len(recs) != 3 || recs[2].Value.Value != 42.0 {
+		t.Errorf("expected the replayed records followed by the publish got %+v", recs)
+	}
+}
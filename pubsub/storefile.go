@@ -0,0 +1,235 @@
+package pubsub
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// compactMinRecords is the smallest log that is worth compacting
+const compactMinRecords = 1024
+
+// FileStore is an append only log of json encoded records, once more than half of the log
+// consists of dropped records it is rewritten with only the retained ones
+type FileStore struct {
+	path    string
+	lock    sync.Mutex
+	file    *os.File
+	written int
+	live    int
+	records map[string][]ValueRecord
+}
+
+type fileStoreRecord struct {
+	RecordId    int         `json:"id"`
+	AttributeID string      `json:"attr"`
+	Value       interface{} `json:"value"`
+	UpdatedBy   string      `json:"by"`
+	UpdatedAt   time.Time   `json:"at"`
+	CausedBy    *RecordRef  `json:"cause,omitempty"`
+	Correlation RecordRef   `json:"corr"`
+	Hops        int         `json:"hops,omitempty"`
+	Inputs      []RecordRef `json:"inputs,omitempty"`
+}
+
+func OpenFileStore(path string) (*FileStore, error) {
+	s := &FileStore{
+		path:    path,
+		records: make(map[string][]ValueRecord),
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	// good is the offset following the last record that was read back
+	var good int64
+	for line := 1; scanner.Scan(); line++ {
+		var r fileStoreRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			if scanner.Scan() {
+				f.Close()
+				return nil, fmt.Errorf("decode %s line %d: %w", path, line, err)
+			}
+			// a bad last line is a write torn by a crash, it is cut off below
+			break
+		}
+		good += int64(len(scanner.Bytes())) + 1
+		s.records[r.AttributeID] = append(s.records[r.AttributeID], ValueRecord{
+			RecordId: r.RecordId,
+			Value: Value{
+				AttributeID: r.AttributeID,
+				Value:       r.Value,
+				UpdatedBy:   r.UpdatedBy,
+				UpdatedAt:   r.UpdatedAt,
+				Correlation: r.Correlation,
+				Hops:        r.Hops,
+				Inputs:      r.Inputs,
+			},
+		})
+		if r.CausedBy != nil {
+			recs := s.records[r.AttributeID]
+			recs[len(recs)-1].CausedBy = *r.CausedBy
+		}
+		s.written++
+		s.live++
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := s.repair(f, good); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return nil, err
+	}
+	s.file = f
+	return s, nil
+}
+
+// repair makes the log end right after its last good record, good is the offset following it as if
+// every record ended in a newline
+func (s *FileStore) repair(f *os.File, good int64) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	switch {
+	case info.Size() > good:
+		return f.Truncate(good)
+	case info.Size() < good:
+		// the last record is whole but lost its newline
+		_, err = f.WriteAt([]byte{'\n'}, info.Size())
+		return err
+	}
+	return nil
+}
+
+func (s *FileStore) Load(attr string) ([]ValueRecord, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]ValueRecord(nil), s.records[attr]...), nil
+}
+
+func (s *FileStore) Append(rec ValueRecord) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.file == nil {
+		return os.ErrClosed
+	}
+	if err := s.write(s.file, rec); err != nil {
+		return err
+	}
+	rec.SubscriptionResponses = nil
+	s.records[rec.AttributeID] = append(s.records[rec.AttributeID], rec)
+	s.written++
+	s.live++
+	return nil
+}
+
+func (s *FileStore) Drop(attr string, recordIds []int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if recs, ok := s.records[attr]; ok {
+		kept := dropRecords(recs, recordIds)
+		s.live -= len(recs) - len(kept)
+		s.records[attr] = kept
+	}
+	if s.written > compactMinRecords && s.live*2 < s.written {
+		return s.compact()
+	}
+	return nil
+}
+
+// Compact rewrites the log with only the retained records
+func (s *FileStore) Compact() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.compact()
+}
+
+func (s *FileStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+func (s *FileStore) write(f *os.File, rec ValueRecord) error {
+	r := fileStoreRecord{
+		RecordId:    rec.RecordId,
+		AttributeID: rec.AttributeID,
+		Value:       rec.Value.Value,
+		UpdatedBy:   rec.UpdatedBy,
+		UpdatedAt:   rec.UpdatedAt,
+		Correlation: rec.Correlation,
+		Hops:        rec.Hops,
+		Inputs:      rec.Inputs,
+	}
+	if !rec.CausedBy.IsZero() {
+		r.CausedBy = &rec.CausedBy
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(b, '\n'))
+	return err
+}
+
+func (s *FileStore) compact() error {
+	if s.file == nil {
+		return os.ErrClosed
+	}
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	written := 0
+	for _, recs := range s.records {
+		for _, rec := range recs {
+			if err := s.write(f, rec); err != nil {
+				f.Close()
+				os.Remove(tmp)
+				return err
+			}
+			written++
+		}
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	s.file.Close()
+	s.file, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	s.written = written
+	s.live = written
+	return nil
+}
@@ -0,0 +1,36 @@
+package pubsub
+
+import "sync"
+
+// MemoryStore keeps history for the lifetime of the process, an attribute that is removed and
+// registered again picks up where it left off
+type MemoryStore struct {
+	lock    sync.RWMutex
+	records map[string][]ValueRecord
+}
+
+func (s *MemoryStore) Load(attr string) ([]ValueRecord, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return append([]ValueRecord(nil), s.records[attr]...), nil
+}
+
+func (s *MemoryStore) Append(rec ValueRecord) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.records == nil {
+		s.records = make(map[string][]ValueRecord)
+	}
+	rec.SubscriptionResponses = nil
+	s.records[rec.AttributeID] = append(s.records[rec.AttributeID], rec)
+	return nil
+}
+
+func (s *MemoryStore) Drop(attr string, recordIds []int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if recs, ok := s.records[attr]; ok {
+		s.records[attr] = dropRecords(recs, recordIds)
+	}
+	return nil
+}
@@ -1,7 +1,30 @@
 package pubsub
 
+import "time"
+
 type Subscription struct {
-	Name   string
+	Name string
+	// Filter selects the attributes delivered to Fn, see ParseFilter for the syntax
 	Filter string
 	Fn     func(ctx Context, v Value)
+	// Timeout bounds the context handed to Fn, a Fn still running past it gets ErrSubscriptionTimeout
+	Timeout time.Duration
+	// Unit converts numeric values to it before Fn sees them, a value that cannot be converted is reported and skipped
+	Unit Unit
+	// Snapshot delivers the current value of every attribute matching Filter, ordered by attribute id,
+	// before any live value. A value is never delivered twice nor skipped between the two
+	Snapshot bool
+	// When restricts the values Fn is called with, see Condition
+	When Condition
+	// Retry calls Fn again with a value it reported errors for
+	Retry RetryPolicy
+	Delivery
+}
+
+// view converts v to the unit of the subscription if it has one
+func (sub Subscription) view(v Value) (Value, error) {
+	if sub.Unit.IsZero() || v.Unit == sub.Unit {
+		return v, nil
+	}
+	return v.In(sub.Unit)
 }
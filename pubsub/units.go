@@ -0,0 +1,196 @@
+package pubsub
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Unit is a unit of measure, a value converts to the base unit of its Dimension as v*Scale + Offset.
+// The zero Unit means a value has no unit
+type Unit struct {
+	Symbol    string
+	Dimension string
+	Scale     float64
+	Offset    float64
+}
+
+var (
+	Kelvin     = Unit{Symbol: "K", Dimension: "temperature", Scale: 1}
+	Celsius    = Unit{Symbol: "°C", Dimension: "temperature", Scale: 1, Offset: 273.15}
+	Fahrenheit = Unit{Symbol: "°F", Dimension: "temperature", Scale: 5.0 / 9, Offset: 273.15 - 32*5.0/9}
+
+	Watt     = Unit{Symbol: "W", Dimension: "power", Scale: 1}
+	Kilowatt = Unit{Symbol: "kW", Dimension: "power", Scale: 1000}
+
+	WattHour     = Unit{Symbol: "Wh", Dimension: "energy", Scale: 1}
+	KilowattHour = Unit{Symbol: "kWh", Dimension: "energy", Scale: 1000}
+
+	Volt      = Unit{Symbol: "V", Dimension: "voltage", Scale: 1}
+	Millivolt = Unit{Symbol: "mV", Dimension: "voltage", Scale: 0.001}
+
+	Ampere      = Unit{Symbol: "A", Dimension: "current", Scale: 1}
+	Milliampere = Unit{Symbol: "mA", Dimension: "current", Scale: 0.001}
+
+	Percent = Unit{Symbol: "%", Dimension: "ratio", Scale: 0.01}
+	Ratio   = Unit{Symbol: "ratio", Dimension: "ratio", Scale: 1}
+
+	Pascal      = Unit{Symbol: "Pa", Dimension: "pressure", Scale: 1}
+	Hectopascal = Unit{Symbol: "hPa", Dimension: "pressure", Scale: 100}
+
+	Millisecond = Unit{Symbol: "ms", Dimension: "time", Scale: 0.001}
+	Second      = Unit{Symbol: "s", Dimension: "time", Scale: 1}
+	Minute      = Unit{Symbol: "min", Dimension: "time", Scale: 60}
+	Hour        = Unit{Symbol: "h", Dimension: "time", Scale: 3600}
+)
+
+var units = struct {
+	lock    sync.RWMutex
+	symbols map[string]Unit
+}{
+	symbols: make(map[string]Unit),
+}
+
+func init() {
+	for _, u := range []Unit{Kelvin, Watt, Kilowatt, WattHour, KilowattHour, Volt, Millivolt, Ampere, Milliampere,
+		Percent, Ratio, Pascal, Hectopascal, Millisecond, Second, Minute, Hour} {
+		RegisterUnit(u)
+	}
+	RegisterUnit(Celsius, "C", "degC")
+	RegisterUnit(Fahrenheit, "F", "degF")
+}
+
+// RegisterUnit makes u known to LookupUnit and ParseQuantity by its symbol and any aliases
+func RegisterUnit(u Unit, aliases ...string) {
+	units.lock.Lock()
+	defer units.lock.Unlock()
+	units.symbols[u.Symbol] = u
+	for _, alias := range aliases {
+		units.symbols[alias] = u
+	}
+}
+
+func LookupUnit(symbol string) (Unit, bool) {
+	units.lock.RLock()
+	defer units.lock.RUnlock()
+	u, ok := units.symbols[symbol]
+	return u, ok
+}
+
+func (u Unit) IsZero() bool {
+	return u.Symbol == ""
+}
+
+func (u Unit) String() string {
+	return u.Symbol
+}
+
+// Convert converts v from u to the unit to, both must measure the same dimension
+func (u Unit) Convert(v float64, to Unit) (float64, error) {
+	if u == to {
+		return v, nil
+	}
+	if u.IsZero() || to.IsZero() || u.Dimension != to.Dimension {
+		return 0, ErrIncompatibleUnit{From: u, To: to}
+	}
+	return (v*u.Scale + u.Offset - to.Offset) / to.Scale, nil
+}
+
+// Quantity is a number tagged with its unit, publishing one to a definition with a compatible unit converts it
+type Quantity struct {
+	Value float64
+	Unit  Unit
+}
+
+func (q Quantity) String() string {
+	return strings.TrimSpace(strconv.FormatFloat(q.Value, 'f', -1, 64) + " " + q.Unit.Symbol)
+}
+
+// ParseQuantity parses a number followed by a registered unit symbol such as "21.5 °C" or "1.2kW"
+func ParseQuantity(s string) (Quantity, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return !(unicode.IsDigit(r) || r == '.' || r == '-' || r == '+' || r == 'e' || r == 'E')
+	})
+	if i <= 0 {
+		return Quantity{}, fmt.Errorf("invalid quantity '%s'", s)
+	}
+	v, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return Quantity{}, fmt.Errorf("invalid quantity '%s': %w", s, err)
+	}
+	symbol := strings.TrimSpace(s[i:])
+	u, ok := LookupUnit(symbol)
+	if !ok {
+		return Quantity{}, ErrUnknownUnit{Symbol: symbol}
+	}
+	return Quantity{Value: v, Unit: u}, nil
+}
+
+// MeasuredDefinition is implemented by definitions whose values carry a unit
+type MeasuredDefinition interface {
+	MeasurementUnit() Unit
+}
+
+// toUnit converts a Quantity, or a string holding one, to the unit u. Anything else is returned
+// untouched for the definition to handle
+func toUnit(v interface{}, u Unit) (interface{}, error) {
+	var q Quantity
+	switch i := v.(type) {
+	case Quantity:
+		q = i
+	case string:
+		if _, err := strconv.ParseFloat(i, 64); err == nil {
+			return v, nil
+		}
+		parsed, err := ParseQuantity(i)
+		if err != nil {
+			if _, unknown := err.(ErrUnknownUnit); unknown {
+				return nil, err
+			}
+			return v, nil
+		}
+		q = parsed
+	default:
+		return v, nil
+	}
+	return q.Unit.Convert(q.Value, u)
+}
+
+func unitOf(d Definition) Unit {
+	if m, ok := d.(MeasuredDefinition); ok {
+		return m.MeasurementUnit()
+	}
+	return Unit{}
+}
+
+func inspectUnit(s string, u Unit) string {
+	if u.IsZero() {
+		return s
+	}
+	return s + " " + u.Symbol
+}
+
+// In converts a numeric value to the unit u, the result is always a float64
+func (v Value) In(u Unit) (Value, error) {
+	if v.Unit == u {
+		return v, nil
+	}
+	f, ok := v.Value.(float64)
+	if i, isInt := v.Value.(int64); isInt {
+		f, ok = float64(i), true
+	}
+	if !ok {
+		return v, ErrIncompatibleUnit{From: v.Unit, To: u}
+	}
+	converted, err := v.Unit.Convert(f, u)
+	if err != nil {
+		return v, err
+	}
+	v.Value = converted
+	v.Unit = u
+	v.inspected = inspectUnit(strconv.FormatFloat(converted, 'f', -1, 64), u)
+	return v, nil
+}
@@ -1,7 +1,9 @@
 package pubsub
 
 import (
+	"fmt"
 	"strings"
+	"unicode"
 )
 
 func isOwner(key, publisher string) bool {
@@ -15,29 +17,38 @@ func isOwner(key, publisher string) bool {
 	return segKey[0] == segPublisher[0]
 }
 
-func KeyMatch(attr string, filter string) bool {
-	segAttr := strings.Split(attr, ".")
-	segFilter := strings.Split(filter, ".")
-
-	if len(segFilter) <= 0 || len(segAttr) <= 0 {
-		return false
+// validateSegment checks a single segment, segments cannot be empty and cannot contain
+// the separators '.' and '@', the filter syntax '*', '>', '?', '{', '}' and ',' or whitespace
+func validateSegment(name, seg string) error {
+	if seg == "" {
+		return ErrInvalidName{Name: name, Reason: "empty segment"}
 	}
-
-	if len(segAttr) > len(segFilter) {
-		segFilter = append(segFilter, make([]string, len(segAttr)-len(segFilter))...)
-	} else {
-		segAttr = append(segAttr, make([]string, len(segFilter)-len(segAttr))...)
-	}
-	for i, f := range segFilter {
-		if f == ">" {
-			return true
-		}
-		if f == "*" {
-			continue
+	for _, c := range seg {
+		switch {
+		case c == '.' || c == '@':
+			return ErrInvalidName{Name: name, Reason: fmt.Sprintf("separator '%c' in segment '%s'", c, seg)}
+		case c == '*' || c == '>' || c == '?':
+			return ErrInvalidName{Name: name, Reason: fmt.Sprintf("wildcard '%c' in segment '%s'", c, seg)}
+		case c == '{' || c == '}' || c == ',':
+			return ErrInvalidName{Name: name, Reason: fmt.Sprintf("alternative syntax '%c' in segment '%s'", c, seg)}
+		case unicode.IsSpace(c) || !unicode.IsPrint(c):
+			return ErrInvalidName{Name: name, Reason: fmt.Sprintf("whitespace or control character in segment '%s'", seg)}
 		}
-		if f != segAttr[i] {
-			return false
+	}
+	return nil
+}
+
+// ValidateNodeId checks that id is a single segment, it is the first segment of every attribute of the node
+func ValidateNodeId(id string) error {
+	return validateSegment(id, id)
+}
+
+// ValidateName checks an attribute or subscription name, one or more segments separated by '.'
+func ValidateName(name string) error {
+	for _, seg := range strings.Split(name, ".") {
+		if err := validateSegment(name, seg); err != nil {
+			return err
 		}
 	}
-	return true
+	return nil
 }
@@ -1,7 +1,10 @@
 package pubsub
 
 import (
+	"errors"
+	"fmt"
 	"testing"
+	"time"
 )
 
 func TestKeyMatch(t *testing.T) {
@@ -74,3 +77,205 @@ func TestKeyMatch(t *testing.T) {
 		}
 	}
 }
+
+func TestKeyMatchExtended(t *testing.T) {
+	tests := []struct {
+		Key    string
+		Filter string
+		Result bool
+	}{
+		// '>' and '*' keep matching a key that already ended
+		{"n1", "n1.>", true},
+		{"n1", "n1.*", true},
+		{"n1", "n1.gpio", false},
+
+		{"house.temperature", "house.**.temperature", true},
+		{"house.kitchen.temperature", "house.**.temperature", true},
+		{"house.up.bedroom.temperature", "house.**.temperature", true},
+		{"house.up.bedroom.humidity", "house.**.temperature", false},
+		{"house.up.bedroom.temperature.max", "house.**.temperature", false},
+		{"house", "house.**", true},
+		{"house.a.b", "house.**", true},
+		{"garden.temperature", "**.temperature", true},
+		{"a.x.b.y.b", "a.**.b.**", true},
+		{"a.x.c", "a.**.b.**", false},
+
+		{"n1.gpio.0", "n1.{gpio,adc}.*", true},
+		{"n1.adc.3", "n1.{gpio,adc}.*", true},
+		{"n1.pwm.0", "n1.{gpio,adc}.*", false},
+		{"n1.gpio", "n1.{gpio,adc}", true},
+		{"n1.gpi", "n1.{gpio,adc}", false},
+		{"n1.gpio.0", "n1.gpio.{0,1,}", true},
+		{"n1.gpio", "n1.gpio.{0,1,}", true},
+		{"n1.gpio", "n1.gpio.{0,1}", false},
+
+		{"sensor-1.temp", "sensor-*.temp", true},
+		{"sensor-.temp", "sensor-*.temp", true},
+		{"sensor.temp", "sensor-*.temp", false},
+		{"n1.gpio.10", "n1.gpio.?", false},
+		{"n1.gpio.7", "n1.gpio.?", true},
+		{"esp-a1b2.config.name", "esp-*.config.*", true},
+		{"n1.relay_kitchen", "n1.relay_{kitchen,hall}*", true},
+		{"n1.relay_hall2", "n1.relay_{kitchen,hall}*", true},
+		{"n1.relay_garage", "n1.relay_{kitchen,hall}*", false},
+
+		{"n1.gpio.0", "> except $sys.>", true},
+		{"$sys.broker.uptime", "> except $sys.>", false},
+		{"n1.gpio.0", "n1.> except n1.gpio.* except n1.adc.*", false},
+		{"n1.adc.0", "n1.> except n1.gpio.* except n1.adc.*", false},
+		{"n1.config.name", "n1.> except n1.gpio.* except n1.adc.*", true},
+		{"n1.config.name", "n1.>   except   n1.gpio.*", true},
+
+		// invalid filters match nothing
+		{"n1.gpio.0", "n1.{gpio.*", false},
+		{"n1.gpio.0", "> except", false},
+	}
+	for _, test := range tests {
+		if KeyMatch(test.Key, test.Filter) != test.Result {
+			t.Errorf("key:%s filter:'%s' expected:%t", test.Key, test.Filter, test.Result)
+		}
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		Filter string
+		Valid  bool
+	}{
+		{">", true},
+		{"*", true},
+		{"n1.gpio.0", true},
+		{"debug.*.gpio.>", true},
+		{"house.**.temperature", true},
+		{"n1.{gpio,adc}.*", true},
+		{"sensor-*.t?mp", true},
+		{"> except $sys.>", true},
+		{"n1.> except n1.gpio.* except n1.adc.*", true},
+		// filters that were accepted before stay valid even when they can never match
+		{"", true},
+		{"a..b", true},
+		{"a.>.b", true},
+
+		{"a.**b", false},
+		{"a.b**", false},
+		{"a.b>", false},
+		{"n1.{gpio", false},
+		{"n1.gpio}", false},
+		{"n1.}gpio{", false},
+		{"n1.{gpio,{adc,pwm}}", false},
+		{"n1.{gpio.adc}", false},
+		{"> except", false},
+		{"> but n1.>", false},
+		{"> except n1.> n2.>", false},
+		{"except >", false},
+		{"n1.\u0007", false},
+	}
+	for _, test := range tests {
+		f, err := ParseFilter(test.Filter)
+		if (err == nil) != test.Valid {
+			t.Errorf("filter:'%s' expected valid:%t got err:%v", test.Filter, test.Valid, err)
+		}
+		if err != nil {
+			if _, ok := err.(ErrInvalidFilter); !ok {
+				t.Errorf("filter:'%s' expected ErrInvalidFilter got %T", test.Filter, err)
+			}
+			continue
+		}
+		if f.String() != test.Filter {
+			t.Errorf("filter:'%s' expected String to return it got '%s'", test.Filter, f.String())
+		}
+	}
+}
+
+func TestSubscriptionFilterSyntax(t *testing.T) {
+	broker := &Broker{}
+	house := BasicNode{ID: "house", Attributes: []Attribute{
+		{Name: "kitchen.temperature", Definition: DoubleDefinition{}},
+		{Name: "up.bedroom.temperature", Definition: DoubleDefinition{}},
+		{Name: "up.bedroom.humidity", Definition: DoubleDefinition{}},
+		{Name: "debug.temperature", Definition: DoubleDefinition{}},
+	}}
+	if err := broker.Register(house); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	dashboard := BasicNode{ID: "dashboard", Subscriptions: []Subscription{{
+		Name:     "temperatures",
+		Filter:   "house.**.temperature except house.debug.>",
+		Snapshot: true,
+		Fn: func(ctx Context, v Value) {
+			got = append(got, v.AttributeID)
+		},
+	}}}
+	if err := broker.Register(dashboard); err != nil {
+		t.Fatal(err)
+	}
+	for _, attr := range []string{"house.up.bedroom.temperature", "house.up.bedroom.humidity", "house.debug.temperature"} {
+		if err := broker.Publish(house, attr, 21); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := fmt.Sprint([]string{"house.kitchen.temperature", "house.up.bedroom.temperature", "house.up.bedroom.temperature"})
+	if fmt.Sprint(got) != expected {
+		t.Errorf("expected %s got %v", expected, got)
+	}
+	if values := broker.Values("house.{kitchen,up}.**.temperature", time.Now()); len(values) != 2 {
+		t.Errorf("expected 2 values got %d", len(values))
+	}
+
+	bad := BasicNode{ID: "bad", Subscriptions: []Subscription{{Name: "s", Filter: "house.{kitchen", Fn: func(ctx Context, v Value) {}}}}
+	if err := broker.Register(bad); !errors.As(err, &ErrInvalidFilter{}) {
+		t.Errorf("expected ErrInvalidFilter got %v", err)
+	}
+}
+
+func TestValidateName(t *testing.T) {
+	tests := []struct {
+		Name  string
+		Valid bool
+	}{
+		{"a1", true},
+		{"gpio.0", true},
+		{"config.name", true},
+		{"monkey_see-monkey_do", true},
+		{"", false},
+		{"gpio.", false},
+		{".gpio", false},
+		{"gpio..0", false},
+		{"gpio.*", false},
+		{"gpio.>", false},
+		{"sensor*", false},
+		{"sensor?", false},
+		{"n.a{b}", false},
+		{"a,b", false},
+		{"n1@sub", false},
+		{"with space", false},
+		{"tab\there", false},
+	}
+	for _, test := range tests {
+		err := ValidateName(test.Name)
+		if (err == nil) != test.Valid {
+			t.Errorf("name:'%s' expected valid:%t got err:%v", test.Name, test.Valid, err)
+		}
+		if err != nil {
+			if _, ok := err.(ErrInvalidName); !ok {
+				t.Errorf("name:'%s' expected ErrInvalidName got %T", test.Name, err)
+			}
+		}
+	}
+
+	for id, valid := range map[string]bool{
+		"n1":          true,
+		"esp-a1b2c3":  true,
+		"":            false,
+		"n1.gpio":     false,
+		"*":           false,
+		">":           false,
+		"node@broker": false,
+	} {
+		if err := ValidateNodeId(id); (err == nil) != valid {
+			t.Errorf("node id:'%s' expected valid:%t got err:%v", id, valid, err)
+		}
+	}
+}
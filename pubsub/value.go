@@ -1,13 +1,40 @@
 package pubsub
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
+
+// RecordRef identifies a single record of an attribute
+type RecordRef struct {
+	AttributeID string `json:"attr"`
+	RecordId    int    `json:"id"`
+}
+
+func (r RecordRef) IsZero() bool {
+	return r.AttributeID == ""
+}
+
+func (r RecordRef) String() string {
+	return fmt.Sprintf("%s#%d", r.AttributeID, r.RecordId)
+}
 
 type Value struct {
 	AttributeID string
 	Value       interface{}
 	UpdatedBy   string
 	UpdatedAt   time.Time
-	inspected   string
+	// CausedBy is the record whose subscription published this value, it is zero for a value published directly
+	CausedBy RecordRef
+	// Correlation is the record that started the chain of publishes, a value published directly is its own root
+	Correlation RecordRef
+	// Hops is the number of subscriptions between this value and the root of its chain
+	Hops int
+	// Inputs are the records a derived value was computed from, see Derivation
+	Inputs []RecordRef
+	// Unit is the unit of a numeric value, it is zero for definitions without one
+	Unit      Unit
+	inspected string
 }
 
 func (v Value) Inspect() string {
@@ -18,9 +45,31 @@ type ValueRecord struct {
 	RecordId int
 	Value
 	SubscriptionResponses []SubscriptionResponse
+	// Suppressed is the number of updates the ChangeFilter of the attribute suppressed since the previous record
+	Suppressed int
+}
+
+func (r ValueRecord) Ref() RecordRef {
+	return RecordRef{AttributeID: r.AttributeID, RecordId: r.RecordId}
 }
 
 type SubscriptionResponse struct {
 	SubscriptionID string
 	Err            []error
+	// Pending is set while the value waits in the queue of an asynchronous subscription
+	Pending bool
+	// Dropped is set when the queue overflowed or the subscription was removed before the value was delivered
+	Dropped bool
+	// QueueDepth is the depth of the queue right after the value was queued
+	QueueDepth int
+	// TotalDropped is the number of values the queue had dropped so far
+	TotalDropped int
+	// Snapshot is set when the value was delivered as part of the snapshot of a new subscription
+	Snapshot bool
+	// Attempts is the number of times Fn was called with the value, Err holds the errors of every attempt
+	Attempts int
+	// Retrying is set while another attempt is scheduled by the RetryPolicy of the subscription
+	Retrying bool
+	// DeadLettered is set once the value was published to the dead letter attribute of the subscription
+	DeadLettered bool
 }